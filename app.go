@@ -0,0 +1,210 @@
+package maintainerbot
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// appTokenRefresh is how long before an installation token expires that
+// AppTokenSource replaces it. It leaves time for the Bot to reload the corpus
+// with the new token.
+const appTokenRefresh = 5 * time.Minute
+
+// AppTokenSource is an oauth2.TokenSource that authenticates as a GitHub App
+// installation. It signs a JWT with the App's private key, exchanges it for
+// an installation access token, and fetches a new token shortly before the
+// current one expires. It is safe for concurrent use.
+//
+// Use it with the TokenSource client option, and set it as the Bot's
+// TokenSource so the corpus uses it too.
+type AppTokenSource struct {
+	// BaseURL is the GitHub API to request tokens from. Defaults to
+	// https://api.github.com/.
+	BaseURL string
+	// Client is the HTTP client used to request tokens. Defaults to
+	// http.DefaultClient.
+	Client *http.Client
+
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// NewAppTokenSource returns an AppTokenSource for the given App and
+// installation. privateKey is the PEM encoded private key downloaded from
+// the App's settings page.
+func NewAppTokenSource(appID, installationID int64, privateKey []byte) (*AppTokenSource, error) {
+	key, err := parseRSAKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("github app private key: %v", err)
+	}
+	return &AppTokenSource{appID: appID, installationID: installationID, key: key}, nil
+}
+
+func parseRSAKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("got a %T, want an RSA key", parsed)
+	}
+	return key, nil
+}
+
+// JWT returns a token that authenticates as the App itself, valid for ten
+// minutes, for the few endpoints that require one.
+func (s *AppTokenSource) JWT() (string, error) {
+	now := time.Now()
+	header := `{"alg":"RS256","typ":"JWT"}`
+	claims, err := json.Marshal(struct {
+		IssuedAt  int64 `json:"iat"`
+		ExpiresAt int64 `json:"exp"`
+		Issuer    int64 `json:"iss"`
+	}{
+		// Allow for clock drift between us and GitHub.
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(9 * time.Minute).Unix(),
+		Issuer:    s.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// Token returns the current installation access token, fetching a new one
+// if it is about to expire.
+func (s *AppTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && time.Until(s.token.Expiry) > 0 {
+		return s.token, nil
+	}
+	token, err := s.fetchToken()
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+func (s *AppTokenSource) fetchToken() (*oauth2.Token, error) {
+	body, err := s.appRequest("POST", fmt.Sprintf("app/installations/%d/access_tokens", s.installationID), http.StatusCreated)
+	if err != nil {
+		return nil, fmt.Errorf("creating installation token: %v", err)
+	}
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("creating installation token: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: result.Token,
+		TokenType:   "token",
+		Expiry:      result.ExpiresAt.Add(-appTokenRefresh),
+	}, nil
+}
+
+// BotLogin returns the login the App's installations act as on GitHub,
+// "<app-slug>[bot]". Comments the App posts are written by this user, but
+// an installation token can't look it up with the authenticated user API.
+func (s *AppTokenSource) BotLogin() (string, error) {
+	body, err := s.appRequest("GET", "app", http.StatusOK)
+	if err != nil {
+		return "", fmt.Errorf("looking up github app: %v", err)
+	}
+	var app struct {
+		Slug string `json:"slug"`
+	}
+	if err := json.Unmarshal(body, &app); err != nil {
+		return "", fmt.Errorf("looking up github app: %v", err)
+	}
+	if app.Slug == "" {
+		return "", errors.New("looking up github app: no slug in response")
+	}
+	return app.Slug + "[bot]", nil
+}
+
+// appRequest sends a request authenticated as the App itself to path,
+// relative to BaseURL, and returns the response body if the response has
+// status want.
+func (s *AppTokenSource) appRequest(method, path string, want int) ([]byte, error) {
+	jwt, err := s.JWT()
+	if err != nil {
+		return nil, err
+	}
+	base := s.BaseURL
+	if base == "" {
+		base = "https://api.github.com/"
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	req, err := http.NewRequest(method, base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.machine-man-preview+json")
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != want {
+		return nil, fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return body, nil
+}
+
+// TokenSource makes the client authenticate with tokens from ts, for example
+// an AppTokenSource, instead of the token passed to NewGitHubClient.
+func TokenSource(ts oauth2.TokenSource) ClientOption {
+	return func(c *clientConfig) {
+		c.tokenSource = ts
+	}
+}
@@ -0,0 +1,99 @@
+package maintainerbot
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppTokenSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	issued := 0
+	lifetime := time.Hour
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method+" "+r.URL.Path != "POST /app/installations/42/access_tokens" && r.Method+" "+r.URL.Path != "GET /app" {
+			http.NotFound(w, r)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+		if len(parts) != 3 {
+			http.Error(w, "bad JWT", http.StatusUnauthorized)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var c struct{ Iss int64 }
+		if json.Unmarshal(claims, &c); c.Iss != 7 {
+			http.Error(w, "wrong issuer", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "GET" {
+			fmt.Fprint(w, `{"id": 7, "slug": "sgbot"}`)
+			return
+		}
+		issued++
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "v1.%d", "expires_at": %q}`, issued, time.Now().Add(lifetime).UTC().Format(time.RFC3339))
+	}))
+	defer s.Close()
+
+	ts, err := NewAppTokenSource(7, 42, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.BaseURL = s.URL
+	for i := 0; i < 2; i++ {
+		token, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "v1.1" {
+			t.Errorf("got token %q, want v1.1", token.AccessToken)
+		}
+	}
+	if issued != 1 {
+		t.Errorf("got %d tokens issued, want 1", issued)
+	}
+
+	// A token that is about to expire is replaced.
+	ts.token.Expiry = time.Now().Add(-time.Second)
+	lifetime = 2 * time.Minute
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "v1.2" {
+		t.Errorf("got token %q after expiry, want v1.2", token.AccessToken)
+	}
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if issued != 3 {
+		t.Errorf("token with less than %v left was reused", appTokenRefresh)
+	}
+
+	if login, err := ts.BotLogin(); err != nil || login != "sgbot[bot]" {
+		t.Errorf("BotLogin: got %q, %v, want sgbot[bot]", login, err)
+	}
+}
@@ -0,0 +1,289 @@
+package maintainerbot
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/time/rate"
+)
+
+// Priority decides which requests get to use a Budget when there isn't
+// enough to go around.
+type Priority int
+
+const (
+	// Background requests, like the corpus sync, use whatever capacity the
+	// Interactive requests leave over, and stop when the rate limit is close
+	// to running out.
+	Background Priority = iota
+	// Interactive requests, like the statuses and comments tasks post, are
+	// served first, and can use the whole remaining rate limit.
+	Interactive
+
+	numPriorities
+)
+
+const (
+	// defaultHourlyLimit is GitHub's rate limit for a personal access token.
+	defaultHourlyLimit = 5000
+	// budgetReserve is the fraction of the hourly limit kept for Interactive
+	// requests. Background requests wait for the limit to reset once less
+	// than this is left.
+	budgetReserve = 0.1
+	// maxBudgetRate caps the request rate, however much of the limit is left,
+	// to stay clear of GitHub's secondary rate limits.
+	maxBudgetRate = rate.Limit(10)
+	// defaultRetryAfter is how long to back off after hitting a secondary
+	// rate limit without a Retry-After header.
+	defaultRetryAfter = time.Minute
+	// usageWindow is how often the Interactive request rate is measured.
+	usageWindow = time.Minute
+)
+
+// Budget shares one GitHub rate limit between every client that uses it and
+// the Bot's corpus sync. It paces requests so the limit lasts until it
+// resets, using the X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset headers on GitHub's responses, and pauses all requests
+// until the reset when the limit runs out, or when GitHub answers with
+// Retry-After or a secondary rate limit error.
+//
+// Capacity is handed out by Priority: Interactive requests may use the whole
+// remaining budget, and Background requests get what the Interactive
+// requests didn't use over the last minute. A Budget is safe for concurrent
+// use.
+type Budget struct {
+	mu          sync.Mutex
+	hourly      int
+	remaining   int // -1 until GitHub has told us
+	reset       time.Time
+	pausedUntil time.Time
+
+	limiters [numPriorities]*rate.Limiter
+	// used counts Interactive requests since windowStart.
+	used        int
+	windowStart time.Time
+	taskRate    rate.Limit
+	timer       *time.Timer
+}
+
+// NewBudget returns a Budget for a token that may make hourly requests an
+// hour. If hourly is 0, it defaults to 5000, GitHub's limit for personal
+// access tokens. The Budget corrects the limit from GitHub's response
+// headers as soon as it sees them.
+func NewBudget(hourly int) *Budget {
+	if hourly <= 0 {
+		hourly = defaultHourlyLimit
+	}
+	b := &Budget{hourly: hourly, remaining: -1, windowStart: time.Now()}
+	for i := range b.limiters {
+		b.limiters[i] = rate.NewLimiter(rate.Limit(float64(hourly)/3600), 20)
+	}
+	b.rebalance(time.Now())
+	return b
+}
+
+// Limiter returns the limiter for requests at priority p, for clients that
+// can't use UseBudget, like maintner's corpus. Requests paced by the limiter
+// alone don't report GitHub's rate limit headers back to the Budget.
+func (b *Budget) Limiter(p Priority) *rate.Limiter {
+	return b.limiters[p]
+}
+
+// UseBudget makes the client draw from b at priority p, instead of from its
+// own fixed rate limit.
+func UseBudget(b *Budget, p Priority) ClientOption {
+	return func(c *clientConfig) {
+		c.budget = b
+		c.priority = p
+	}
+}
+
+// wait blocks until a request at priority p may be sent.
+func (b *Budget) wait(ctx context.Context, p Priority) error {
+	if err := b.waitPause(ctx); err != nil {
+		return err
+	}
+	if err := b.limiters[p].Wait(ctx); err != nil {
+		return err
+	}
+	if p == Interactive {
+		b.mu.Lock()
+		b.used++
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// waitPause blocks while GitHub has asked us to stop sending requests.
+func (b *Budget) waitPause(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		d := time.Until(b.pausedUntil)
+		b.mu.Unlock()
+		if d <= 0 {
+			return nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// observe updates the budget from the headers of resp.
+func (b *Budget) observe(resp *http.Response) {
+	now := time.Now()
+	h := resp.Header
+	// The search API has its own, much smaller, limit.
+	if res := h.Get("X-RateLimit-Resource"); res != "" && res != "core" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if limit, err := strconv.Atoi(h.Get("X-RateLimit-Limit")); err == nil && limit > 0 {
+		b.hourly = limit
+	}
+	if remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining")); err == nil {
+		b.remaining = remaining
+	}
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		b.reset = time.Unix(reset, 0)
+	}
+	var pause time.Duration
+	if secs, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		pause = time.Duration(secs) * time.Second
+	}
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
+		switch {
+		case pause > 0:
+		case b.remaining == 0 && b.reset.After(now):
+			pause = b.reset.Sub(now)
+		case isSecondaryRateLimit(resp):
+			pause = defaultRetryAfter
+		}
+	}
+	if pause > 0 {
+		if until := now.Add(pause); until.After(b.pausedUntil) {
+			log.Printf("GitHub rate limit hit, pausing requests for %v", pause.Round(time.Second))
+			b.pausedUntil = until
+		}
+	}
+	b.rebalance(now)
+}
+
+// isSecondaryRateLimit reports whether resp is GitHub's response to abuse
+// detection, which doesn't always come with a Retry-After header. It leaves
+// resp.Body readable.
+func isSecondaryRateLimit(resp *http.Response) bool {
+	if resp.Body == nil {
+		return false
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	msg := strings.ToLower(string(body))
+	return strings.Contains(msg, "secondary rate limit") || strings.Contains(msg, "abuse detection")
+}
+
+// setRate updates the budget from the rate limit reported by the
+// /rate_limit endpoint.
+func (b *Budget) setRate(r *github.Rate) {
+	if r == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.Limit > 0 {
+		b.hourly = r.Limit
+	}
+	b.remaining = r.Remaining
+	b.reset = r.Reset.Time
+	b.rebalance(time.Now())
+}
+
+// rebalance divides what is left of the rate limit between the priorities.
+// The caller must hold b.mu.
+func (b *Budget) rebalance(now time.Time) {
+	if elapsed := now.Sub(b.windowStart); elapsed >= usageWindow {
+		b.taskRate = rate.Limit(float64(b.used) / elapsed.Seconds())
+		b.used = 0
+		b.windowStart = now
+	}
+	total := rate.Limit(float64(b.hourly) / 3600)
+	untilReset := b.reset.Sub(now)
+	if b.remaining >= 0 && untilReset > 0 {
+		total = rate.Limit(float64(b.remaining) / untilReset.Seconds())
+		// A limiter never recovers from a zero rate: every request after
+		// that eats into its burst for good. Once the limit runs out,
+		// keep a token per window and hold requests until the reset.
+		if floor := rate.Every(untilReset); total < floor {
+			total = floor
+		}
+		if b.remaining == 0 && b.pausedUntil.Before(b.reset) {
+			b.pausedUntil = b.reset
+		}
+	}
+	if total > maxBudgetRate {
+		total = maxBudgetRate
+	}
+	background := total - b.taskRate
+	if background < total/10 {
+		background = total / 10
+	}
+	if b.remaining >= 0 && untilReset > 0 && float64(b.remaining) < budgetReserve*float64(b.hourly) {
+		// Keep what's left for Interactive requests.
+		background = rate.Every(untilReset)
+	}
+	b.limiters[Interactive].SetLimitAt(now, total)
+	b.limiters[Background].SetLimitAt(now, background)
+
+	// Restore the full rate once the limit resets, even if no requests come
+	// in to tell us.
+	if untilReset > 0 {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		b.timer = time.AfterFunc(untilReset+time.Second, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if !time.Now().Before(b.reset) {
+				b.remaining = -1
+			}
+			b.rebalance(time.Now())
+		})
+	}
+}
+
+// budgetTransport paces requests with a Budget and reports GitHub's rate
+// limit headers back to it.
+type budgetTransport struct {
+	budget   *Budget
+	priority Priority
+	base     http.RoundTripper
+}
+
+func (t budgetTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.budget.wait(r.Context(), t.priority); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	t.budget.observe(resp)
+	return resp, nil
+}
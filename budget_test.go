@@ -0,0 +1,110 @@
+package maintainerbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/time/rate"
+)
+
+func rateHeaders(remaining int, reset time.Time) http.Header {
+	h := make(http.Header)
+	h.Set("X-RateLimit-Limit", "5000")
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	return h
+}
+
+func TestBudgetPriorities(t *testing.T) {
+	b := NewBudget(0)
+	reset := time.Now().Add(10 * time.Minute)
+	b.observe(&http.Response{StatusCode: 200, Header: rateHeaders(3000, reset)})
+	interactive := b.Limiter(Interactive).Limit()
+	background := b.Limiter(Background).Limit()
+	// 3000 requests over 10 minutes is 5/s.
+	if interactive < 4.5 || interactive > 5.5 {
+		t.Errorf("interactive rate: got %v, want about 5/s", interactive)
+	}
+	if background != interactive {
+		t.Errorf("background rate with no task traffic: got %v, want %v", background, interactive)
+	}
+
+	// Close to the limit, the corpus has to wait for the reset, but tasks can
+	// use what is left.
+	b.observe(&http.Response{StatusCode: 200, Header: rateHeaders(300, reset)})
+	if got := b.Limiter(Background).Limit(); got > rate.Every(5*time.Minute) {
+		t.Errorf("background rate near the limit: got %v, want it paused", got)
+	}
+	if got := b.Limiter(Interactive).Limit(); got < 0.4 {
+		t.Errorf("interactive rate near the limit: got %v, want about 0.5/s", got)
+	}
+}
+
+func TestBudgetRetryAfter(t *testing.T) {
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "You have exceeded a secondary rate limit."}`))
+	}))
+	defer s.Close()
+	budget := NewBudget(0)
+	ghc := NewGitHubClient("token", 0, BaseURL(s.URL), UseBudget(budget, Interactive))
+	ctx := context.Background()
+	if _, _, err := ghc.Issues.Get(ctx, "sourcegraph", "sourcegraph", 1); err == nil {
+		t.Fatal("expected an error")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := ghc.Issues.Get(ctx, "sourcegraph", "sourcegraph", 1); err == nil {
+		t.Fatal("expected the second request to wait out Retry-After")
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+}
+
+func TestBudgetSecondaryRateLimit(t *testing.T) {
+	b := NewBudget(0)
+	resp := httptest.NewRecorder()
+	resp.WriteHeader(http.StatusForbidden)
+	resp.WriteString(`{"message": "You have triggered an abuse detection mechanism."}`)
+	b.observe(resp.Result())
+	if d := time.Until(b.pausedUntil); d < 50*time.Second {
+		t.Errorf("got pause of %v after abuse detection, want about a minute", d)
+	}
+}
+
+func TestBudgetExhausted(t *testing.T) {
+	b := NewBudget(0)
+	reset := time.Now().Add(100 * time.Millisecond)
+	b.setRate(&github.Rate{Limit: 5000, Remaining: 0, Reset: github.Timestamp{Time: reset}})
+	if got := b.Limiter(Interactive).Limit(); got <= 0 {
+		t.Fatalf("interactive rate with no requests left: got %v, want more than 0", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// More requests than the limiters' burst, all after the reset.
+	for i := 0; i < 25; i++ {
+		if err := b.wait(ctx, Interactive); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if time.Now().Before(reset) {
+			t.Fatalf("request %d was sent before the limit reset", i)
+		}
+	}
+	// The timer puts the normal rate back once the window is over.
+	want := rate.Limit(5000.0 / 3600)
+	for b.Limiter(Interactive).Limit() != want {
+		if ctx.Err() != nil {
+			t.Fatalf("interactive rate after the reset: got %v, want %v", b.Limiter(Interactive).Limit(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
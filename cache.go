@@ -0,0 +1,251 @@
+package maintainerbot
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CacheStore holds the responses saved by the Cache client option.
+// Implementations must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns the data stored for key, and whether it was found.
+	Get(key string) ([]byte, bool)
+	// Set stores data under key.
+	Set(key string, data []byte)
+	// Delete removes key.
+	Delete(key string)
+}
+
+// Cache makes the client remember the ETag and Last-Modified headers of
+// GitHub's responses in store, and send conditional requests for resources it
+// has seen before. If the resource hasn't changed, GitHub answers with a 304
+// that doesn't count against the rate limit, and the client returns the
+// response from the cache instead.
+//
+// Only GET requests are cached. Every request is still sent to GitHub, so
+// responses are never stale.
+func Cache(store CacheStore) ClientOption {
+	return func(c *clientConfig) {
+		c.cache = store
+	}
+}
+
+type cacheTransport struct {
+	store CacheStore
+	base  http.RoundTripper
+}
+
+// cacheKey identifies a request in the cache. Preview APIs return different
+// responses for the same URL, so the Accept header is part of the key.
+func cacheKey(r *http.Request) string {
+	return r.URL.String() + " " + r.Header.Get("Accept")
+}
+
+func (t cacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != "GET" || r.Header.Get("Range") != "" {
+		return t.base.RoundTrip(r)
+	}
+	key := cacheKey(r)
+	cached := t.cached(key, r)
+	req := r
+	if cached != nil {
+		// RoundTrippers must not modify the request they are given.
+		req = new(http.Request)
+		*req = *r
+		req.Header = make(http.Header, len(r.Header)+2)
+		for k, v := range r.Header {
+			req.Header[k] = v
+		}
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastMod := cached.Header.Get("Last-Modified"); lastMod != "" {
+			req.Header.Set("If-Modified-Since", lastMod)
+		}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		// Pass on the current rate limit, rather than the one saved with the
+		// response.
+		for k, v := range resp.Header {
+			if k == "Date" || strings.HasPrefix(k, "X-Ratelimit-") {
+				cached.Header[k] = v
+			}
+		}
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	if resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
+		t.store.Delete(key)
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	saved := *resp
+	saved.Body = ioutil.NopCloser(bytes.NewReader(body))
+	saved.TransferEncoding = nil
+	if data, err := httputil.DumpResponse(&saved, true); err == nil {
+		t.store.Set(key, data)
+	}
+	return resp, nil
+}
+
+// cached returns the response stored for key, or nil.
+func (t cacheTransport) cached(key string, r *http.Request) *http.Response {
+	data, ok := t.store.Get(key)
+	if !ok {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), r)
+	if err != nil {
+		t.store.Delete(key)
+		return nil
+	}
+	return resp
+}
+
+// MemoryCache is a CacheStore that keeps the most recently used responses in
+// memory.
+type MemoryCache struct {
+	mu      sync.Mutex
+	max     int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key  string
+	data []byte
+}
+
+// NewMemoryCache returns a MemoryCache that holds up to maxEntries
+// responses. If maxEntries is 0, it defaults to 1000.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	return &MemoryCache{
+		max:     maxEntries,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get implements CacheStore.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*memoryCacheEntry).data, true
+}
+
+// Set implements CacheStore.
+func (c *MemoryCache) Set(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*memoryCacheEntry).data = data
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&memoryCacheEntry{key, data})
+	if c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// Delete implements CacheStore.
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// DiskCache is a CacheStore that keeps responses in files in a directory, so
+// they survive a restart.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a DiskCache that stores responses in dir, creating
+// it if needed.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get implements CacheStore.
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Set implements CacheStore. Errors are ignored; the response is fetched
+// again next time.
+func (c *DiskCache) Set(key string, data []byte) {
+	f, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Delete implements CacheStore.
+func (c *DiskCache) Delete(key string) {
+	os.Remove(c.path(key))
+}
+
+// HTTPCache returns a DiskCache in the "httpcache" directory under the Bot's
+// DataDir, for use with the Cache client option.
+func (b *Bot) HTTPCache() (*DiskCache, error) {
+	return NewDiskCache(filepath.Join(b.DataDir, "httpcache"))
+}
@@ -0,0 +1,68 @@
+package maintainerbot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintainerbot-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	disk, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]CacheStore{"memory": NewMemoryCache(0), "disk": disk} {
+		t.Run(name, func(t *testing.T) {
+			title := "Fix typo"
+			var full, notModified int
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				etag := `"` + title + `"`
+				w.Header().Set("X-RateLimit-Remaining", "4000")
+				if r.Header.Get("If-None-Match") == etag {
+					notModified++
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				full++
+				w.Header().Set("ETag", etag)
+				w.Write([]byte(`{"number": 7, "title": "` + title + `"}`))
+			}))
+			defer s.Close()
+			ghc := NewGitHubClient("token", time.Nanosecond, BaseURL(s.URL), Cache(store))
+			ctx := context.Background()
+			get := func() string {
+				t.Helper()
+				issue, _, err := ghc.Issues.Get(ctx, "sourcegraph", "sourcegraph", 7)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return issue.GetTitle()
+			}
+			if got := get(); got != "Fix typo" {
+				t.Errorf("first request: got title %q", got)
+			}
+			if got := get(); got != "Fix typo" {
+				t.Errorf("cached request: got title %q", got)
+			}
+			if full != 1 || notModified != 1 {
+				t.Errorf("got %d full and %d conditional responses, want 1 and 1", full, notModified)
+			}
+			title = "Fix typos"
+			if got := get(); got != "Fix typos" {
+				t.Errorf("after a change: got title %q", got)
+			}
+			if full != 2 {
+				t.Errorf("changed resource wasn't fetched again")
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package maintainerbot
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ClientOption configures a client created by NewGitHubClient.
+type ClientOption func(*clientConfig)
+
+type clientConfig struct {
+	recorder *Recorder
+	baseURL  *url.URL
+	budget   *Budget
+	priority Priority
+	retry    *RetryPolicy
+	cache    CacheStore
+
+	tokenSource oauth2.TokenSource
+}
+
+// BaseURL sends the client's API requests to u instead of
+// https://api.github.com/, for example to talk to GitHub Enterprise or to the
+// fake server in the maintainerbottest package. BaseURL panics if u is not a
+// valid URL.
+func BaseURL(u string) ClientOption {
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		panic(err)
+	}
+	return func(c *clientConfig) {
+		c.baseURL = parsed
+	}
+}
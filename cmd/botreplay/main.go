@@ -0,0 +1,92 @@
+// The botreplay command freezes a maintainerbot corpus at a point in time, and
+// compares the changes two versions of a bot planned against it.
+//
+// To find out what a change to a Task would have done last week, snapshot the
+// bot's data directory:
+//
+//	botreplay snapshot -data-dir $HOME/var/sgbot -until 2018-10-01T12:00:00Z -out /tmp/snap
+//
+// then replay each version of the bot against the snapshot (for sgbot, with
+// its -replay flag) and diff the results:
+//
+//	sgbot-old -replay /tmp/snap > old.json
+//	sgbot-new -replay /tmp/snap > new.json
+//	botreplay diff old.json new.json
+//
+// diff exits with status 1 if the two runs planned different changes.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/sourcegraph/maintainerbot"
+)
+
+func usage() {
+	os.Stderr.WriteString(`usage: botreplay snapshot -data-dir <dir> -until <time> -out <dir>
+       botreplay diff <old.json> <new.json>
+`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "snapshot":
+		snapshot(os.Args[2:])
+	case "diff":
+		diff(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func snapshot(args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	dataDir := fs.String("data-dir", "", "The bot's data directory")
+	until := fs.String("until", "", "Leave out changes after this time, in RFC 3339 format, e.g. 2018-10-01T12:00:00Z")
+	out := fs.String("out", "", "Directory to write the snapshot to")
+	fs.Parse(args)
+	if *dataDir == "" || *until == "" || *out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	t, err := time.Parse(time.RFC3339, *until)
+	if err != nil {
+		log.Fatalf("invalid -until: %v", err)
+	}
+	if err := maintainerbot.Snapshot(*dataDir, *out, t); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func diff(args []string) {
+	if len(args) != 2 {
+		usage()
+	}
+	old, err := maintainerbot.ReadActionsFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	new, err := maintainerbot.ReadActionsFile(args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	removed, added := maintainerbot.DiffActions(old, new)
+	for _, a := range removed {
+		fmt.Printf("- %s\n", a)
+	}
+	for _, a := range added {
+		fmt.Printf("+ %s\n", a)
+	}
+	if len(removed) > 0 || len(added) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,154 @@
+package maintainerbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// PlannedAction is a GitHub API write that was captured by a Recorder
+// instead of being sent to GitHub.
+type PlannedAction struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	// Path is the API path, for example
+	// "/repos/sourcegraph/sourcegraph/statuses/<sha>".
+	Path string `json:"path"`
+	// Body is the JSON request body, if any.
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// Recorder captures the writes made by a GitHub client in dry-run mode. It is
+// safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	actions []PlannedAction
+}
+
+// NewRecorder creates an empty Recorder.
+func NewRecorder() *Recorder {
+	return new(Recorder)
+}
+
+func (r *Recorder) record(a PlannedAction) {
+	r.mu.Lock()
+	r.actions = append(r.actions, a)
+	r.mu.Unlock()
+}
+
+// Actions returns every action captured so far, in the order the requests
+// were made.
+func (r *Recorder) Actions() []PlannedAction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]PlannedAction(nil), r.actions...)
+}
+
+// Drain returns every action captured so far and clears the Recorder.
+func (r *Recorder) Drain() []PlannedAction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	actions := r.actions
+	r.actions = nil
+	return actions
+}
+
+// WriteJSON writes the captured actions to w as an indented JSON array.
+func (r *Recorder) WriteJSON(w io.Writer) error {
+	actions := r.Actions()
+	if actions == nil {
+		actions = []PlannedAction{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(actions)
+}
+
+// DryRun puts the client in dry-run mode. Requests that would change
+// something on GitHub (POST, PATCH, PUT and DELETE) are captured in rec and
+// answered with an empty successful response, and are not counted against
+// the rate limit. Reads are sent to GitHub as usual.
+func DryRun(rec *Recorder) ClientOption {
+	return func(c *clientConfig) {
+		c.recorder = rec
+	}
+}
+
+type dryRunTransport struct {
+	recorder *Recorder
+	base     http.RoundTripper
+}
+
+func (t dryRunTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return t.base.RoundTrip(r)
+	}
+	action := PlannedAction{
+		Time:   time.Now(),
+		Method: r.Method,
+		Path:   r.URL.Path,
+	}
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			action.Body = json.RawMessage(body)
+		}
+	}
+	t.recorder.record(action)
+	status := http.StatusOK
+	if r.Method == "DELETE" {
+		status = http.StatusNoContent
+	}
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte("{}"))),
+		ContentLength: 2,
+		Request:       r,
+	}, nil
+}
+
+// reportDryRun logs the actions captured by b.Recorder since the last call,
+// and appends them to b.DryRunFile as JSON, one action per line.
+func (b *Bot) reportDryRun() {
+	if b.Recorder == nil {
+		return
+	}
+	actions := b.Recorder.Drain()
+	for _, a := range actions {
+		log.Printf("dry run: would %s %s %s", a.Method, a.Path, a.Body)
+	}
+	if b.DryRunFile == "" || len(actions) == 0 {
+		return
+	}
+	f, err := os.OpenFile(b.DryRunFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Printf("dry run: %v", err)
+		return
+	}
+	enc := json.NewEncoder(f)
+	for _, a := range actions {
+		if err := enc.Encode(a); err != nil {
+			log.Printf("dry run: writing %s: %v", b.DryRunFile, err)
+			break
+		}
+	}
+	if err := f.Close(); err != nil {
+		log.Printf("dry run: writing %s: %v", b.DryRunFile, err)
+	}
+}
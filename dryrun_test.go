@@ -0,0 +1,80 @@
+package maintainerbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestDryRun(t *testing.T) {
+	var methods []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Write([]byte(`{"number": 7, "title": "Fix typo"}`))
+	}))
+	defer s.Close()
+	rec := NewRecorder()
+	ghc := NewGitHubClient("token", 1, DryRun(rec))
+	ghc.BaseURL, _ = url.Parse(s.URL + "/")
+
+	ctx := context.Background()
+	issue, _, err := ghc.Issues.Get(ctx, "sourcegraph", "sourcegraph", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issue.GetTitle() != "Fix typo" {
+		t.Errorf("read was not sent to the server: got title %q", issue.GetTitle())
+	}
+	_, _, err = ghc.Repositories.CreateStatus(ctx, "sourcegraph", "sourcegraph", "abc123", &github.RepoStatus{
+		State:   github.String("failure"),
+		Context: github.String("cla-bot"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ghc.Issues.RemoveLabelForIssue(ctx, "sourcegraph", "sourcegraph", 7, "bug"); err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 1 || methods[0] != "GET" {
+		t.Errorf("expected only the GET to reach the server, got %v", methods)
+	}
+
+	actions := rec.Actions()
+	if len(actions) != 2 {
+		t.Fatalf("got %d planned actions, want 2", len(actions))
+	}
+	if actions[0].Method != "POST" || actions[0].Path != "/repos/sourcegraph/sourcegraph/statuses/abc123" {
+		t.Errorf("wrong first action: %s %s", actions[0].Method, actions[0].Path)
+	}
+	var status github.RepoStatus
+	if err := json.Unmarshal(actions[0].Body, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.GetState() != "failure" {
+		t.Errorf("wrong status state recorded: %q", status.GetState())
+	}
+	if actions[1].Method != "DELETE" || actions[1].Body != nil {
+		t.Errorf("wrong second action: %s %s %s", actions[1].Method, actions[1].Path, actions[1].Body)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := rec.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []PlannedAction
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 {
+		t.Errorf("WriteJSON: got %d actions, want 2", len(decoded))
+	}
+	if drained := rec.Drain(); len(drained) != 2 || len(rec.Actions()) != 0 {
+		t.Errorf("Drain should return and clear the actions")
+	}
+}
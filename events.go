@@ -0,0 +1,271 @@
+package maintainerbot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/build/maintner"
+)
+
+// EventType describes what changed about an issue or pull request.
+type EventType string
+
+const (
+	// IssueOpened is sent when a new issue or pull request appears.
+	IssueOpened EventType = "issue_opened"
+	// IssueClosed is sent when an issue or pull request is closed or merged.
+	IssueClosed EventType = "issue_closed"
+	// IssueReopened is sent when a closed issue or pull request is reopened.
+	IssueReopened EventType = "issue_reopened"
+	// LabelAdded and LabelRemoved are sent when a label is added to or
+	// removed from an issue. Event.Label holds the label name.
+	LabelAdded   EventType = "label_added"
+	LabelRemoved EventType = "label_removed"
+	// CommentCreated is sent for each new comment. Event.Comment holds the
+	// comment.
+	CommentCreated EventType = "comment_created"
+	// PRHeadChanged is sent when new commits are pushed to a pull request.
+	// Event.HeadSHA holds the new head commit. The corpus doesn't track
+	// commits on pull requests, so this event is only sent when the Bot
+	// receives a "synchronize" pull_request webhook; see WebhookHandler.
+	PRHeadChanged EventType = "pr_head_changed"
+)
+
+// Event is a single change to an issue or pull request, computed by comparing
+// the corpus before and after a sync.
+type Event struct {
+	Type   EventType
+	Repo   maintner.GitHubRepoID
+	Number int32
+	// Issue is the issue or pull request that changed, as of the time the
+	// Event is delivered. It may be nil if the corpus has not caught up with
+	// a webhook yet.
+	Issue   *maintner.GitHubIssue
+	Label   string
+	Comment *maintner.GitHubComment
+	HeadSHA string
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s#%d: %s", e.Repo, e.Number, e.Type)
+}
+
+// EventTask can be implemented by a Task that only wants to react to what
+// changed, instead of scanning the whole repository every time it runs.
+//
+// The first time the Task runs against a repository, the Bot calls Do, so the
+// Task can catch up with the existing state. After that, the Bot calls
+// HandleEvents with every Event since the Task last ran, and skips the Task
+// if nothing changed. If HandleEvents returns an error, the same events are
+// delivered again on the next run, along with any new ones.
+type EventTask interface {
+	Task
+	HandleEvents(ctx context.Context, repo *maintner.GitHubRepo, events []Event) error
+}
+
+// maxPendingEvents bounds the number of undelivered events kept per Task and
+// repository.
+const maxPendingEvents = 10000
+
+// issueSnapshot is the part of an issue's state that the Bot diffs between
+// syncs.
+type issueSnapshot struct {
+	closed        bool
+	labels        map[string]bool
+	lastCommentID int64
+}
+
+type repoSnapshot map[int32]issueSnapshot
+
+func takeSnapshot(repo *maintner.GitHubRepo) repoSnapshot {
+	snap := make(repoSnapshot)
+	repo.ForeachIssue(func(gi *maintner.GitHubIssue) error {
+		if gi.NotExist {
+			return nil
+		}
+		is := issueSnapshot{closed: gi.Closed, labels: make(map[string]bool, len(gi.Labels))}
+		for _, label := range gi.Labels {
+			is.labels[label.Name] = true
+		}
+		gi.ForeachComment(func(c *maintner.GitHubComment) error {
+			if c.ID > is.lastCommentID {
+				is.lastCommentID = c.ID
+			}
+			return nil
+		})
+		snap[gi.Number] = is
+		return nil
+	})
+	return snap
+}
+
+// diffRepo returns the events that turn prev into the current state of repo.
+// GitHub comment IDs increase over time, so any comment with an ID above the
+// previous maximum is new.
+func diffRepo(prev repoSnapshot, repo *maintner.GitHubRepo, cur repoSnapshot) []Event {
+	var events []Event
+	id := repo.ID()
+	repo.ForeachIssue(func(gi *maintner.GitHubIssue) error {
+		now, ok := cur[gi.Number]
+		if !ok {
+			return nil
+		}
+		ev := Event{Repo: id, Number: gi.Number}
+		was, existed := prev[gi.Number]
+		if !existed {
+			ev.Type = IssueOpened
+			events = append(events, ev)
+			was = issueSnapshot{}
+		}
+		if now.closed && !was.closed {
+			ev.Type = IssueClosed
+			events = append(events, ev)
+		} else if !now.closed && was.closed {
+			ev.Type = IssueReopened
+			events = append(events, ev)
+		}
+		for name := range now.labels {
+			if !was.labels[name] {
+				events = append(events, Event{Type: LabelAdded, Repo: id, Number: gi.Number, Label: name})
+			}
+		}
+		for name := range was.labels {
+			if !now.labels[name] {
+				events = append(events, Event{Type: LabelRemoved, Repo: id, Number: gi.Number, Label: name})
+			}
+		}
+		if now.lastCommentID > was.lastCommentID {
+			gi.ForeachComment(func(c *maintner.GitHubComment) error {
+				if c.ID > was.lastCommentID {
+					events = append(events, Event{Type: CommentCreated, Repo: id, Number: gi.Number, Comment: c})
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return events
+}
+
+// hasEventTasks reports whether any registered Task implements EventTask.
+func (b *Bot) hasEventTasks() bool {
+	for _, e := range b.tasks {
+		if _, ok := e.task.(EventTask); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// collectEvents diffs every watched repository against its snapshot from the
+// previous call, and queues the changes for each EventTask. The caller must
+// hold the corpus read lock.
+func (b *Bot) collectEvents() {
+	if !b.hasEventTasks() {
+		return
+	}
+	if b.snapshots == nil {
+		b.snapshots = make(map[maintner.GitHubRepoID]repoSnapshot)
+	}
+	for _, repo := range b.repos {
+		id := repo.ID()
+		cur := takeSnapshot(repo)
+		prev, ok := b.snapshots[id]
+		b.snapshots[id] = cur
+		if !ok {
+			// First look at this repo; EventTasks start with a call to Do.
+			continue
+		}
+		b.queueEvents(diffRepo(prev, repo, cur)...)
+	}
+}
+
+// queueEvents adds events to the queue of every EventTask that wants them.
+func (b *Bot) queueEvents(events ...Event) {
+	b.eventMu.Lock()
+	defer b.eventMu.Unlock()
+	for _, e := range b.tasks {
+		if _, ok := e.task.(EventTask); !ok {
+			continue
+		}
+		for _, ev := range events {
+			if !e.caughtUp[ev.Repo] || !e.wantRepo(ev.Repo) {
+				continue
+			}
+			if e.pending == nil {
+				e.pending = make(map[maintner.GitHubRepoID][]Event)
+			}
+			if len(e.pending[ev.Repo]) >= maxPendingEvents {
+				// The Task has been failing for a long time. Drop the
+				// backlog and let Do catch up once it recovers.
+				delete(e.pending, ev.Repo)
+				e.caughtUp[ev.Repo] = false
+				continue
+			}
+			e.pending[ev.Repo] = append(e.pending[ev.Repo], ev)
+		}
+	}
+}
+
+// queueWebhookEvents converts webhook deliveries that the corpus can't see
+// into Events.
+func (b *Bot) queueWebhookEvents(event interface{}) {
+	pr, ok := event.(*github.PullRequestEvent)
+	if !ok || pr.GetAction() != "synchronize" {
+		return
+	}
+	b.queueEvents(Event{
+		Type:    PRHeadChanged,
+		Repo:    webhookRepo(event),
+		Number:  int32(pr.GetNumber()),
+		HeadSHA: pr.GetPullRequest().GetHead().GetSHA(),
+	})
+}
+
+// doEvents runs an EventTask against repo: Do the first time, and
+// HandleEvents with any queued events after that.
+func (b *Bot) doEvents(ctx context.Context, e *taskEntry, repo *maintner.GitHubRepo) error {
+	et := e.task.(EventTask)
+	id := repo.ID()
+	b.eventMu.Lock()
+	caughtUp := e.caughtUp[id]
+	events := e.pending[id]
+	delete(e.pending, id)
+	b.eventMu.Unlock()
+
+	if !caughtUp {
+		// Start queueing before Do, so nothing that happens while it runs is
+		// lost. Events that Do already handled may be delivered again.
+		b.eventMu.Lock()
+		if e.caughtUp == nil {
+			e.caughtUp = make(map[maintner.GitHubRepoID]bool)
+		}
+		e.caughtUp[id] = true
+		b.eventMu.Unlock()
+		err := e.do(ctx, repo)
+		if err != nil {
+			b.eventMu.Lock()
+			e.caughtUp[id] = false
+			delete(e.pending, id)
+			b.eventMu.Unlock()
+		}
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	for i := range events {
+		events[i].Issue = repo.Issue(events[i].Number)
+	}
+	err := e.call(ctx, func(ctx context.Context) error {
+		return et.HandleEvents(ctx, repo, events)
+	})
+	if err != nil {
+		// Put the events back in front of anything queued since.
+		b.eventMu.Lock()
+		e.pending[id] = append(events, e.pending[id]...)
+		b.eventMu.Unlock()
+	}
+	return err
+}
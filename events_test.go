@@ -0,0 +1,66 @@
+package maintainerbot
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/build/maintner"
+)
+
+type recordingEventTask struct {
+	dos     int
+	batches [][]Event
+	fail    bool
+}
+
+func (r *recordingEventTask) Do(ctx context.Context, repo *maintner.GitHubRepo) error {
+	r.dos++
+	return nil
+}
+
+func (r *recordingEventTask) HandleEvents(ctx context.Context, repo *maintner.GitHubRepo, events []Event) error {
+	r.batches = append(r.batches, events)
+	if r.fail {
+		return errors.New("try again")
+	}
+	return nil
+}
+
+func TestEventTaskDelivery(t *testing.T) {
+	b := new(Bot)
+	repo := new(maintner.GitHubRepo)
+	b.repos = []*maintner.GitHubRepo{repo}
+	task := new(recordingEventTask)
+	b.RegisterTask(task)
+
+	// Events before the first run are covered by Do.
+	b.queueEvents(Event{Type: IssueOpened, Repo: repo.ID(), Number: 1})
+	b.doTasks(context.Background())
+	if task.dos != 1 || len(task.batches) != 0 {
+		t.Fatalf("first run: got %d calls to Do and %d batches, want 1 and 0", task.dos, len(task.batches))
+	}
+
+	// Nothing changed, so HandleEvents isn't called.
+	b.doTasks(context.Background())
+	if task.dos != 1 || len(task.batches) != 0 {
+		t.Fatalf("idle run: got %d calls to Do and %d batches, want 1 and 0", task.dos, len(task.batches))
+	}
+
+	task.fail = true
+	b.queueEvents(Event{Type: LabelAdded, Repo: repo.ID(), Number: 2, Label: "bug"})
+	b.runTask(context.Background(), b.tasks[0])
+	task.fail = false
+	b.queueEvents(Event{Type: IssueClosed, Repo: repo.ID(), Number: 2})
+	b.runTask(context.Background(), b.tasks[0])
+	if len(task.batches) != 2 {
+		t.Fatalf("got %d batches, want 2", len(task.batches))
+	}
+	retried := task.batches[1]
+	if len(retried) != 2 || retried[0].Type != LabelAdded || retried[1].Type != IssueClosed {
+		t.Errorf("failed events were not redelivered in order: %v", retried)
+	}
+	if task.dos != 1 {
+		t.Errorf("got %d calls to Do, want 1", task.dos)
+	}
+}
@@ -10,13 +10,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/go-github/github"
@@ -39,12 +43,104 @@ func getGithubToken() (string, error) {
 	return f[1], nil
 }
 
-func getGithubClient(rateLimit time.Duration) (*github.Client, error) {
-	token, err := getGithubToken()
+// getAppTokenSource returns the token source for the GitHub App configured
+// with the -github-app-* flags, or nil if sgbot should use a personal access
+// token.
+func getAppTokenSource() (*maintainerbot.AppTokenSource, error) {
+	if *githubAppID == 0 {
+		return nil, nil
+	}
+	if *githubInstallationID == 0 || *githubAppKeyFile == "" {
+		return nil, fmt.Errorf("-github-app-id requires -github-installation-id and -github-app-key")
+	}
+	key, err := ioutil.ReadFile(*githubAppKeyFile)
 	if err != nil {
 		return nil, err
 	}
-	return maintainerbot.NewGitHubClient(token, rateLimit), nil
+	return maintainerbot.NewAppTokenSource(*githubAppID, *githubInstallationID, key)
+}
+
+// newCLAChecker returns sgbot's CLAChecker. botLogin is the login sgbot
+// comments as when it is a GitHub App, or "" to look it up.
+func newCLAChecker(ghc *github.Client, botLogin string) *tasks.CLAChecker {
+	var fetcher tasks.ContributorFetcher
+	if *contributorsFile != "" {
+		fileFetcher := tasks.NewFileFetcher(*contributorsFile)
+		fileFetcher.ColumnName = "GitHub Handle"
+		fetcher = fileFetcher
+	} else {
+		spreadsheetFetcher := tasks.NewSpreadsheetFetcher(*spreadsheetURL)
+		spreadsheetFetcher.ColumnName = "GitHub Handle"
+		fetcher = spreadsheetFetcher
+	}
+	// Keep using the last list we loaded if the source is slow or down.
+	fetcher = tasks.Cached(tasks.WithTimeout(fetcher, 30*time.Second), filepath.Join(*dataDir, "contributors.json"))
+	cla := tasks.NewCLAChecker(ghc, *claURL, fetcher)
+	cla.CanSkipCLA = func(pr *github.PullRequest, files []*github.CommitFile) bool {
+		return claSkipReason(pr, files) != ""
+	}
+	cla.SkipReason = claSkipReason
+	cla.UseCheckRuns = *claCheckRuns
+	cla.CheckAllAuthors = *claAllAuthors
+	cla.RefreshInterval = *claRefresh
+	cla.BotLogin = botLogin
+	if *claComment {
+		cla.SetComments(`Thanks for the pull request, @{{ .Username }}! Before we can merge it, {{ range $i, $who := .Missing }}{{ if $i }}, {{ end }}{{ $who }}{{ end }} must <a href="{{ .CLAURL }}">sign our Contributor License Agreement</a>.
+
+This comment will be updated once the CLA is signed.`,
+			`{{ if .Skipped }}This pull request doesn't need a CLA after all.{{ else }}Thanks for signing the CLA, @{{ .Username }}!{{ end }}`)
+	}
+	return cla
+}
+
+// claSkipReason returns why pr doesn't need a CLA, or "" if it does.
+func claSkipReason(pr *github.PullRequest, files []*github.CommitFile) string {
+	if pr == nil || files == nil {
+		panic("nil PR or nil files in CanSkipCLA check; can't compare")
+	}
+	if n := pr.GetAdditions() + pr.GetDeletions(); n <= 15 {
+		return fmt.Sprintf("It only changes %d lines.", n)
+	}
+	for i := range files {
+		if !strings.HasSuffix(files[i].GetFilename(), ".md") {
+			return ""
+		}
+	}
+	return "It only changes Markdown files."
+}
+
+func newCongratulator(ghc *github.Client) *tasks.Congratulator {
+	return tasks.NewCongratulator(ghc, `Thanks for the contribution, @{{ .Username }}!
+
+You should receive feedback on your pull request within a few days. If you haven't already, please read through <a href="https://github.com/sourcegraph/sourcegraph/blob/master/CONTRIBUTING.md"> the contributing guide</a>, and ensure that you've <a href="`+*claURL+`">signed the CLA</a>.
+
+Did you run into any issues when creating this PR? Please describe them in <a href="https://github.com/sourcegraph/sourcegraph/issues/new/choose">an issue</a> so we can make the experience better for the next contributor.
+`)
+}
+
+// replay runs sgbot's tasks once against the corpus snapshot in dir, and
+// writes the changes they would have made to stdout as JSON.
+func replay(ctx context.Context, dir, token, botLogin string, opts ...maintainerbot.ClientOption) error {
+	var actions []maintainerbot.PlannedAction
+	for _, newTask := range []func(*github.Client) maintainerbot.Task{
+		func(ghc *github.Client) maintainerbot.Task {
+			cla := newCLAChecker(ghc, botLogin)
+			if err := cla.StartFetch(ctx); err != nil {
+				log.Fatal(err)
+			}
+			return cla
+		},
+		func(ghc *github.Client) maintainerbot.Task { return newCongratulator(ghc) },
+	} {
+		planned, err := maintainerbot.Replay(ctx, dir, token, newTask, opts...)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, planned...)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(actions)
 }
 
 var dataDir = flag.String("data-dir", filepath.Join(os.Getenv("HOME"), "var", "sgbot"), "Local directory to write protobuf files to (default $HOME/var/sgbot)")
@@ -52,12 +148,26 @@ var dataDir = flag.String("data-dir", filepath.Join(os.Getenv("HOME"), "var", "s
 // Github allows 5000 queries per hour which is roughly one query every 720ms,
 // we set this as the default value. A lower Duration means you can complete
 // queries more quickly, however, you may hit the rate limit and get blocked
-// until the hour limit (which Github applies on a rolling basis) expires.
+// until the hour limit (which Github applies on a rolling basis) expires. This
+// is only a starting point; sgbot paces itself with the limit GitHub reports
+// once it sees a response.
 var githubRateLimit = flag.Duration("github-rate", time.Hour/5000, "Rate to limit GitHub requests (amount of time to pass between requests)")
 var githubTokenFile = flag.String("github-token-file", filepath.Join(os.Getenv("HOME"), "keys", "github-sgbot"), `File to load Github token from. File should be of form <username>:<token>`)
+var githubAppID = flag.Int64("github-app-id", 0, "Authenticate as this GitHub App instead of with a token from -github-token-file")
+var githubInstallationID = flag.Int64("github-installation-id", 0, "With -github-app-id, the ID of the App's installation on the watched repos")
+var githubAppKeyFile = flag.String("github-app-key", "", "With -github-app-id, the file containing the App's PEM encoded private key")
 var spreadsheetURL = flag.String("spreadsheet-url", "", "Spreadsheet URL for loading contributors")
+var contributorsFile = flag.String("contributors-file", "", "Load contributors from this CSV, YAML or plain text file instead of -spreadsheet-url")
 var claURL = flag.String("cla-url", "", "URL where users can sign the CLA")
-var githubRepo = flag.String("repo", "sourcegraph/sourcegraph", "Github repo to watch, in owner/repo-name format")
+var claAllAuthors = flag.Bool("cla-all-authors", false, "Require every commit author, committer and co-author on a PR to have signed the CLA, not just the PR's author")
+var claComment = flag.Bool("cla-comment", false, "Comment on PRs whose authors haven't signed the CLA, and update the comment once they have")
+var claRefresh = flag.Duration("cla-refresh", time.Minute, "How often to load the list of contributors again")
+var claCheckRuns = flag.Bool("cla-check-runs", false, "Publish CLA results as check runs with a Re-run button, instead of commit statuses. Requires -github-app-id")
+var dryRun = flag.Bool("dry-run", false, "Log the changes sgbot would make on GitHub instead of making them")
+var dryRunFile = flag.String("dry-run-file", "", "With -dry-run, also append the planned changes to this file as JSON")
+var webhookAddr = flag.String("webhook-addr", "", "Address to listen for GitHub webhooks on, e.g. ':8080'. The secret is read from $GITHUB_WEBHOOK_SECRET. Disabled if empty. If $CLA_RELOAD_SECRET is set, POST requests to /cla-signed with it as a bearer token reload the list of contributors")
+var replayDir = flag.String("replay", "", "Run the tasks once against the corpus snapshot in this directory, and print the changes they would make as JSON instead of making them")
+var githubRepo = flag.String("repo", "sourcegraph/sourcegraph", "Comma-separated Github repos to watch, in owner/repo-name format. Use owner/* to watch every repo in an organization")
 
 func init() {
 	flag.Usage = func() {
@@ -68,8 +178,8 @@ func init() {
 
 func main() {
 	flag.Parse()
-	if *spreadsheetURL == "" {
-		fmt.Fprintf(os.Stderr, "Please provide a spreadsheet URL\n")
+	if *spreadsheetURL == "" && *contributorsFile == "" {
+		fmt.Fprintf(os.Stderr, "Please provide a spreadsheet URL or a contributors file\n")
 		flag.Usage()
 		os.Exit(2)
 	}
@@ -78,51 +188,117 @@ func main() {
 		flag.Usage()
 		os.Exit(2)
 	}
+	if *claCheckRuns && *githubAppID == 0 {
+		fmt.Fprintf(os.Stderr, "-cla-check-runs requires -github-app-id\n")
+		flag.Usage()
+		os.Exit(2)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("received %v, shutting down", sig)
+		cancel()
+	}()
 
-	token, err := getGithubToken()
+	appTokens, err := getAppTokenSource()
 	if err != nil {
 		log.Fatal(err)
 	}
-	ghc, err := getGithubClient(*githubRateLimit / 3)
-	if err != nil {
+	var token, botLogin string
+	var authOpts []maintainerbot.ClientOption
+	if appTokens != nil {
+		authOpts = append(authOpts, maintainerbot.TokenSource(appTokens))
+		if botLogin, err = appTokens.BotLogin(); err != nil {
+			log.Fatal(err)
+		}
+	} else if token, err = getGithubToken(); err != nil {
 		log.Fatal(err)
 	}
-	splits := strings.SplitN(*githubRepo, "/", 2)
-	if len(splits) != 2 || splits[1] == "" {
-		log.Fatalf("Invalid github repo: %s. Should be 'owner/repo'", *githubRepo)
+	if *replayDir != "" {
+		if err := replay(ctx, *replayDir, token, botLogin, authOpts...); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	bot := maintainerbot.New(splits[0], splits[1], token)
-	bot.DataDir = *dataDir
-	bot.GitHubRateLimit = *githubRateLimit / 3 * 2
-	spreadsheetFetcher := tasks.NewSpreadsheetFetcher(*spreadsheetURL)
-	spreadsheetFetcher.ColumnName = "GitHub Handle"
-	cla := tasks.NewCLAChecker(ghc, *claURL, spreadsheetFetcher)
-	cla.CanSkipCLA = func(pr *github.PullRequest, files []*github.CommitFile) bool {
-		if pr == nil || files == nil {
-			panic("nil PR or nil files in CanSkipCLA check; can't compare")
+	var bot *maintainerbot.Bot
+	for i, repo := range strings.Split(*githubRepo, ",") {
+		splits := strings.SplitN(strings.TrimSpace(repo), "/", 2)
+		if len(splits) != 2 || splits[0] == "" || splits[1] == "" {
+			log.Fatalf("Invalid github repo: %s. Should be 'owner/repo' or 'owner/*'", repo)
 		}
-		if pr.GetAdditions()+pr.GetDeletions() <= 15 {
-			return true
+		owner, name := splits[0], splits[1]
+		if name == "*" {
+			name = ""
 		}
-		hasOnlyMarkdownFiles := true
-		for i := range files {
-			if !strings.HasSuffix(files[i].GetFilename(), ".md") {
-				hasOnlyMarkdownFiles = false
-				break
-			}
+		if i == 0 {
+			bot = maintainerbot.New(owner, name, token)
+		} else {
+			bot.WatchRepo(owner, name)
 		}
-		return hasOnlyMarkdownFiles
 	}
-	cla.StartFetch(ctx)
+	bot.DataDir = *dataDir
+	var recorder *maintainerbot.Recorder
+	// The corpus sync and the tasks share one rate limit; the Budget paces
+	// both from GitHub's rate limit headers, and serves the tasks first.
+	budget := maintainerbot.NewBudget(int(time.Hour / *githubRateLimit))
+	retries := new(maintainerbot.RetryMetrics)
+	clientOpts := append(authOpts,
+		maintainerbot.UseBudget(budget, maintainerbot.Interactive),
+		maintainerbot.Retry(maintainerbot.RetryPolicy{Metrics: retries}),
+	)
+	if *dryRun {
+		recorder = maintainerbot.NewRecorder()
+		clientOpts = append(clientOpts, maintainerbot.DryRun(recorder))
+	}
+	// Responses that haven't changed since the last cycle come back as 304s,
+	// which don't count against the rate limit.
+	cache, err := bot.HTTPCache()
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientOpts = append(clientOpts, maintainerbot.Cache(cache))
+	ghc := maintainerbot.NewGitHubClient(token, *githubRateLimit, clientOpts...)
+	bot.Recorder = recorder
+	bot.DryRunFile = *dryRunFile
+	bot.Budget = budget
+	if appTokens != nil {
+		bot.TokenSource = appTokens
+	}
+	cla := newCLAChecker(ghc, botLogin)
+	if cla.Store, err = bot.State("cla"); err != nil {
+		log.Fatal(err)
+	}
+	if err := cla.StartFetch(ctx); err != nil {
+		log.Fatal(err)
+	}
 	bot.RegisterTask(cla)
-	congratulator := tasks.NewCongratulator(ghc, `Thanks for the contribution, @{{ .Username }}!
-
-You should receive feedback on your pull request within a few days. If you haven't already, please read through <a href="https://github.com/sourcegraph/sourcegraph/blob/master/CONTRIBUTING.md"> the contributing guide</a>, and ensure that you've <a href="`+*claURL+`">signed the CLA</a>.
-
-Did you run into any issues when creating this PR? Please describe them in <a href="https://github.com/sourcegraph/sourcegraph/issues/new/choose">an issue</a> so we can make the experience better for the next contributor.
-`)
+	congratulator := newCongratulator(ghc)
+	if congratulator.Store, err = bot.State("congratulator"); err != nil {
+		log.Fatal(err)
+	}
 	bot.RegisterTask(congratulator)
-	bot.Run(ctx)
+	if *webhookAddr != "" {
+		secret := os.Getenv("GITHUB_WEBHOOK_SECRET")
+		if secret == "" {
+			log.Fatal("Please set GITHUB_WEBHOOK_SECRET to receive webhooks")
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/webhook", bot.WebhookHandler([]byte(secret)))
+		if reloadSecret := os.Getenv("CLA_RELOAD_SECRET"); reloadSecret != "" {
+			mux.Handle("/cla-signed", cla.ReloadHandler(reloadSecret, bot.Wake))
+		}
+		go func() {
+			log.Fatal(http.ListenAndServe(*webhookAddr, mux))
+		}()
+	}
+	err = bot.Run(ctx)
+	if stats := retries.Stats(); stats.Retried > 0 {
+		log.Printf("retried %d of %d GitHub requests (%d gave up): %v", stats.Retried, stats.Requests, stats.GaveUp, stats.Reasons)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
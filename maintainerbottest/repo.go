@@ -0,0 +1,125 @@
+package maintainerbottest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"golang.org/x/build/maintner"
+	"golang.org/x/build/maintner/maintpb"
+)
+
+// Issue describes an issue or pull request for NewGitHubRepo.
+type Issue struct {
+	Number      int32
+	Title       string
+	Body        string
+	User        string
+	PullRequest bool
+	Closed      bool
+	Labels      []string
+	Comments    []Comment
+	// Created defaults to the time NewGitHubRepo was called.
+	Created time.Time
+	// Updated defaults to Created.
+	Updated time.Time
+}
+
+// Comment describes a comment on an Issue.
+type Comment struct {
+	User string
+	Body string
+}
+
+// NewGitHubRepo returns a maintner.GitHubRepo that contains issues, without
+// talking to GitHub. Tasks can be run against the returned repo like any
+// other; comments are given increasing IDs in the order they appear.
+func NewGitHubRepo(owner, repo string, issues ...Issue) (*maintner.GitHubRepo, error) {
+	src := make(mutationSource, 0, len(issues))
+	now := time.Now()
+	users := make(map[string]int64)
+	user := func(login string) *maintpb.GithubUser {
+		if login == "" {
+			return nil
+		}
+		if _, ok := users[login]; !ok {
+			users[login] = int64(len(users) + 1)
+		}
+		return &maintpb.GithubUser{Id: users[login], Login: login}
+	}
+	labels := make(map[string]int64)
+	var commentID int64
+	for _, is := range issues {
+		if is.Number <= 0 {
+			return nil, fmt.Errorf("maintainerbottest: issue has invalid number %d", is.Number)
+		}
+		created := is.Created
+		if created.IsZero() {
+			created = now
+		}
+		ts, err := ptypes.TimestampProto(created)
+		if err != nil {
+			return nil, err
+		}
+		updatedTS := ts
+		if !is.Updated.IsZero() {
+			if updatedTS, err = ptypes.TimestampProto(is.Updated); err != nil {
+				return nil, err
+			}
+		}
+		m := &maintpb.GithubIssueMutation{
+			Owner:       owner,
+			Repo:        repo,
+			Number:      is.Number,
+			Id:          int64(is.Number),
+			User:        user(is.User),
+			Title:       is.Title,
+			Body:        is.Body,
+			Created:     ts,
+			Updated:     updatedTS,
+			PullRequest: is.PullRequest,
+			Closed:      &maintpb.BoolChange{Val: is.Closed},
+		}
+		for _, name := range is.Labels {
+			if _, ok := labels[name]; !ok {
+				labels[name] = int64(len(labels) + 1)
+			}
+			m.AddLabel = append(m.AddLabel, &maintpb.GithubLabel{Id: labels[name], Name: name})
+		}
+		for _, c := range is.Comments {
+			commentID++
+			m.Comment = append(m.Comment, &maintpb.GithubIssueCommentMutation{
+				Id:      commentID,
+				User:    user(c.User),
+				Body:    c.Body,
+				Created: ts,
+				Updated: ts,
+			})
+		}
+		src = append(src, &maintpb.Mutation{GithubIssue: m})
+	}
+	corpus := new(maintner.Corpus)
+	if err := corpus.Initialize(context.Background(), src); err != nil {
+		return nil, err
+	}
+	r := corpus.GitHub().Repo(owner, repo)
+	if r == nil {
+		return nil, fmt.Errorf("maintainerbottest: no issues for %s/%s", owner, repo)
+	}
+	return r, nil
+}
+
+// mutationSource is a maintner.MutationSource that replays a fixed list of
+// mutations.
+type mutationSource []*maintpb.Mutation
+
+func (s mutationSource) GetMutations(ctx context.Context) <-chan maintner.MutationStreamEvent {
+	ch := make(chan maintner.MutationStreamEvent, len(s)+1)
+	for _, m := range s {
+		ch <- maintner.MutationStreamEvent{Mutation: m}
+	}
+	ch <- maintner.MutationStreamEvent{End: true}
+	close(ch)
+	return ch
+}
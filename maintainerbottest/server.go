@@ -0,0 +1,747 @@
+// Package maintainerbottest provides a fake GitHub API server and corpus
+// fixtures, so tasks can be tested without talking to GitHub.
+//
+// A typical test seeds a Server with the pull requests a task will look up,
+// builds a matching maintner.GitHubRepo with NewGitHubRepo, runs the task
+// against both, and then asserts on the statuses, comments and labels the
+// Server received:
+//
+//	s := maintainerbottest.NewServer()
+//	defer s.Close()
+//	s.AddPullRequest("sourcegraph", "sourcegraph", &github.PullRequest{
+//		Number: github.Int(7),
+//		Head:   &github.PullRequestBranch{SHA: github.String("abc123")},
+//	}, nil)
+//	repo, err := maintainerbottest.NewGitHubRepo("sourcegraph", "sourcegraph",
+//		maintainerbottest.Issue{Number: 7, User: "kevinburke", PullRequest: true})
+//	...
+//	err = task.Do(ctx, repo)
+//	statuses := s.Statuses("sourcegraph", "sourcegraph", "abc123")
+package maintainerbottest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/sourcegraph/maintainerbot"
+)
+
+// Server is an in-process fake of the parts of the GitHub REST API that
+// maintainerbot tasks use: issues, pull requests and their files and commits,
+// labels, comments, commit statuses, check runs, and the users, organization
+// members and teams needed to check a CLA. It is safe for concurrent use.
+//
+// Requests for anything the Server doesn't know about get a 404, like they
+// would from GitHub.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	nextID    int64
+	issues    map[string]*github.Issue
+	pulls     map[string]*github.PullRequest
+	files     map[string][]*github.CommitFile
+	commits   map[string][]*github.RepositoryCommit
+	labels    map[string][]string
+	comments  map[string][]*github.IssueComment
+	statuses  map[string][]*github.RepoStatus
+	checkRuns map[string][]*github.CheckRun
+	users     map[string]*github.User
+	members   map[string][]string
+	teams     map[string][]*github.Team
+	teamUsers map[int64][]string
+	requests  []string
+}
+
+// Login is the user the Server's clients are authenticated as. Comments they
+// post are attributed to it, and they can only edit their own comments.
+const Login = "maintainerbot"
+
+// NewServer starts a new Server. Call Close when you are done with it.
+func NewServer() *Server {
+	s := &Server{
+		nextID:    1000,
+		issues:    make(map[string]*github.Issue),
+		pulls:     make(map[string]*github.PullRequest),
+		files:     make(map[string][]*github.CommitFile),
+		commits:   make(map[string][]*github.RepositoryCommit),
+		labels:    make(map[string][]string),
+		comments:  make(map[string][]*github.IssueComment),
+		statuses:  make(map[string][]*github.RepoStatus),
+		checkRuns: make(map[string][]*github.CheckRun),
+		users:     make(map[string]*github.User),
+		members:   make(map[string][]string),
+		teams:     make(map[string][]*github.Team),
+		teamUsers: make(map[int64][]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns a GitHub client that talks to s. opts are passed on to
+// maintainerbot.NewGitHubClient.
+func (s *Server) Client(opts ...maintainerbot.ClientOption) *github.Client {
+	opts = append([]maintainerbot.ClientOption{maintainerbot.BaseURL(s.URL)}, opts...)
+	return maintainerbot.NewGitHubClient("maintainerbottest", time.Nanosecond, opts...)
+}
+
+func issueKey(owner, repo string, number int) string {
+	return fmt.Sprintf("%s/%s#%d", owner, repo, number)
+}
+
+func refKey(owner, repo, ref string) string {
+	return owner + "/" + repo + "@" + ref
+}
+
+func (s *Server) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// AddIssue adds an issue to the server. issue.Number must be set.
+func (s *Server) AddIssue(owner, repo string, issue *github.Issue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issues[issueKey(owner, repo, issue.GetNumber())] = issue
+	for _, label := range issue.Labels {
+		s.addLabel(issueKey(owner, repo, issue.GetNumber()), label.GetName())
+	}
+}
+
+// AddPullRequest adds a pull request, along with the files it changes, to the
+// server. pr.Number must be set, and pr.Head.SHA should be set for tasks that
+// post statuses.
+func (s *Server) AddPullRequest(owner, repo string, pr *github.PullRequest, files []*github.CommitFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := issueKey(owner, repo, pr.GetNumber())
+	s.pulls[key] = pr
+	s.files[key] = files
+	if _, ok := s.issues[key]; !ok {
+		s.issues[key] = &github.Issue{
+			Number: pr.Number,
+			Title:  pr.Title,
+			User:   pr.User,
+			State:  pr.State,
+		}
+	}
+}
+
+// SetPullRequestCommits sets the commits listed for a pull request added with
+// AddPullRequest.
+func (s *Server) SetPullRequestCommits(owner, repo string, number int, commits []*github.RepositoryCommit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commits[issueKey(owner, repo, number)] = commits
+}
+
+// AddUser adds a user's profile to the server. user.Login must be set.
+func (s *Server) AddUser(user *github.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[strings.ToLower(user.GetLogin())] = user
+}
+
+// AddOrgMember makes login a member of org.
+func (s *Server) AddOrgMember(org, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(org)
+	s.members[key] = append(s.members[key], login)
+}
+
+// AddTeam adds a team to org, with the given members, and returns its ID.
+func (s *Server) AddTeam(org, slug string, members ...string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	team := &github.Team{
+		ID:   github.Int64(s.id()),
+		Name: github.String(slug),
+		Slug: github.String(slug),
+	}
+	key := strings.ToLower(org)
+	s.teams[key] = append(s.teams[key], team)
+	s.teamUsers[team.GetID()] = members
+	return team.GetID()
+}
+
+// AddStatus adds a commit status to ref, as if it had been posted earlier.
+func (s *Server) AddStatus(owner, repo, ref string, status *github.RepoStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addStatus(refKey(owner, repo, ref), status)
+}
+
+func (s *Server) addStatus(key string, status *github.RepoStatus) {
+	if status.ID == nil {
+		status.ID = github.Int64(s.id())
+	}
+	// GitHub lists the newest status first.
+	s.statuses[key] = append([]*github.RepoStatus{status}, s.statuses[key]...)
+}
+
+// AddComment adds a comment to an issue or pull request, as if it had been
+// posted earlier.
+func (s *Server) AddComment(owner, repo string, number int, comment *github.IssueComment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if comment.ID == nil {
+		comment.ID = github.Int64(s.id())
+	}
+	key := issueKey(owner, repo, number)
+	s.comments[key] = append(s.comments[key], comment)
+}
+
+func (s *Server) addLabel(key, name string) {
+	for _, l := range s.labels[key] {
+		if l == name {
+			return
+		}
+	}
+	s.labels[key] = append(s.labels[key], name)
+}
+
+// Statuses returns every status posted to ref, newest first.
+func (s *Server) Statuses(owner, repo, ref string) []*github.RepoStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*github.RepoStatus(nil), s.statuses[refKey(owner, repo, ref)]...)
+}
+
+// Comments returns the comments on an issue or pull request, oldest first.
+func (s *Server) Comments(owner, repo string, number int) []*github.IssueComment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*github.IssueComment(nil), s.comments[issueKey(owner, repo, number)]...)
+}
+
+// Labels returns the names of the labels on an issue or pull request, in
+// sorted order.
+func (s *Server) Labels(owner, repo string, number int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	labels := append([]string(nil), s.labels[issueKey(owner, repo, number)]...)
+	sort.Strings(labels)
+	return labels
+}
+
+// CheckRuns returns every check run created for ref, oldest first. The path
+// and level of an annotation are in its FileName and WarningLevel.
+func (s *Server) CheckRuns(owner, repo, ref string) []*github.CheckRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*github.CheckRun(nil), s.checkRuns[refKey(owner, repo, ref)]...)
+}
+
+// Requests returns the method and path of every request the server has
+// received, for example "GET /repos/sourcegraph/sourcegraph/pulls/7".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// match reports whether path matches pattern, where "*" in pattern matches
+// any single path segment, and returns the matched segments.
+func match(path, pattern string) ([]string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	pat := strings.Split(pattern, "/")
+	if len(parts) != len(pat) {
+		return nil, false
+	}
+	var vars []string
+	for i := range pat {
+		switch {
+		case pat[i] == "*":
+			vars = append(vars, parts[i])
+		case pat[i] != parts[i]:
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	for _, route := range routes {
+		if route.method != r.Method {
+			continue
+		}
+		if vars, ok := match(r.URL.Path, route.pattern); ok {
+			route.handle(s, w, r, vars)
+			return
+		}
+	}
+	notFound(w)
+}
+
+type route struct {
+	method, pattern string
+	handle          func(s *Server, w http.ResponseWriter, r *http.Request, vars []string)
+}
+
+var routes = []route{
+	{"GET", "repos/*/*/issues/*", (*Server).getIssue},
+	{"GET", "repos/*/*/pulls/*", (*Server).getPull},
+	{"GET", "repos/*/*/pulls/*/files", (*Server).listFiles},
+	{"GET", "repos/*/*/pulls/*/commits", (*Server).listCommits},
+	{"GET", "repos/*/*/issues/*/labels", (*Server).listLabels},
+	{"POST", "repos/*/*/issues/*/labels", (*Server).addLabels},
+	{"DELETE", "repos/*/*/issues/*/labels/*", (*Server).removeLabel},
+	{"GET", "repos/*/*/issues/*/comments", (*Server).listComments},
+	{"POST", "repos/*/*/issues/*/comments", (*Server).createComment},
+	{"PATCH", "repos/*/*/issues/comments/*", (*Server).editComment},
+	{"GET", "repos/*/*/commits/*/statuses", (*Server).listStatuses},
+	{"POST", "repos/*/*/statuses/*", (*Server).createStatus},
+	{"GET", "repos/*/*/commits/*/check-runs", (*Server).listCheckRuns},
+	{"POST", "repos/*/*/check-runs", (*Server).createCheckRun},
+	{"PATCH", "repos/*/*/check-runs/*", (*Server).updateCheckRun},
+	{"GET", "user", (*Server).getAuthenticatedUser},
+	{"GET", "users/*", (*Server).getUser},
+	{"GET", "orgs/*/members", (*Server).listMembers},
+	{"GET", "orgs/*/members/*", (*Server).checkMember},
+	{"GET", "orgs/*/teams", (*Server).listTeams},
+	{"GET", "teams/*/members", (*Server).listTeamMembers},
+	{"GET", "teams/*/memberships/*", (*Server).getTeamMembership},
+}
+
+// number parses the issue number in vars[2].
+func number(w http.ResponseWriter, vars []string) (int, bool) {
+	n, err := strconv.Atoi(vars[2])
+	if err != nil {
+		notFound(w)
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *Server) getIssue(w http.ResponseWriter, r *http.Request, vars []string) {
+	n, ok := number(w, vars)
+	if !ok {
+		return
+	}
+	issue, ok := s.issues[issueKey(vars[0], vars[1], n)]
+	if !ok {
+		notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, issue)
+}
+
+func (s *Server) getPull(w http.ResponseWriter, r *http.Request, vars []string) {
+	n, ok := number(w, vars)
+	if !ok {
+		return
+	}
+	pr, ok := s.pulls[issueKey(vars[0], vars[1], n)]
+	if !ok {
+		notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, pr)
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request, vars []string) {
+	n, ok := number(w, vars)
+	if !ok {
+		return
+	}
+	key := issueKey(vars[0], vars[1], n)
+	if _, ok := s.pulls[key]; !ok {
+		notFound(w)
+		return
+	}
+	files := s.files[key]
+	if files == nil {
+		files = []*github.CommitFile{}
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+func (s *Server) listCommits(w http.ResponseWriter, r *http.Request, vars []string) {
+	n, ok := number(w, vars)
+	if !ok {
+		return
+	}
+	key := issueKey(vars[0], vars[1], n)
+	if _, ok := s.pulls[key]; !ok {
+		notFound(w)
+		return
+	}
+	commits := s.commits[key]
+	if commits == nil {
+		commits = []*github.RepositoryCommit{}
+	}
+	writeJSON(w, http.StatusOK, commits)
+}
+
+func (s *Server) labelList(key string) []*github.Label {
+	labels := make([]*github.Label, 0, len(s.labels[key]))
+	for _, name := range s.labels[key] {
+		labels = append(labels, &github.Label{Name: github.String(name)})
+	}
+	return labels
+}
+
+func (s *Server) listLabels(w http.ResponseWriter, r *http.Request, vars []string) {
+	n, ok := number(w, vars)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.labelList(issueKey(vars[0], vars[1], n)))
+}
+
+func (s *Server) addLabels(w http.ResponseWriter, r *http.Request, vars []string) {
+	n, ok := number(w, vars)
+	if !ok {
+		return
+	}
+	var names []string
+	if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	key := issueKey(vars[0], vars[1], n)
+	for _, name := range names {
+		s.addLabel(key, name)
+	}
+	writeJSON(w, http.StatusOK, s.labelList(key))
+}
+
+func (s *Server) removeLabel(w http.ResponseWriter, r *http.Request, vars []string) {
+	n, ok := number(w, vars)
+	if !ok {
+		return
+	}
+	key := issueKey(vars[0], vars[1], n)
+	labels := s.labels[key][:0]
+	for _, name := range s.labels[key] {
+		if name != vars[3] {
+			labels = append(labels, name)
+		}
+	}
+	s.labels[key] = labels
+	writeJSON(w, http.StatusOK, s.labelList(key))
+}
+
+func (s *Server) listComments(w http.ResponseWriter, r *http.Request, vars []string) {
+	n, ok := number(w, vars)
+	if !ok {
+		return
+	}
+	comments := s.comments[issueKey(vars[0], vars[1], n)]
+	if comments == nil {
+		comments = []*github.IssueComment{}
+	}
+	writeJSON(w, http.StatusOK, comments)
+}
+
+func (s *Server) createComment(w http.ResponseWriter, r *http.Request, vars []string) {
+	n, ok := number(w, vars)
+	if !ok {
+		return
+	}
+	comment := new(github.IssueComment)
+	if err := json.NewDecoder(r.Body).Decode(comment); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	comment.ID = github.Int64(s.id())
+	comment.User = &github.User{Login: github.String(Login)}
+	now := time.Now()
+	comment.CreatedAt = &now
+	comment.UpdatedAt = &now
+	key := issueKey(vars[0], vars[1], n)
+	s.comments[key] = append(s.comments[key], comment)
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+func (s *Server) editComment(w http.ResponseWriter, r *http.Request, vars []string) {
+	id, err := strconv.ParseInt(vars[2], 10, 64)
+	if err != nil {
+		notFound(w)
+		return
+	}
+	prefix := vars[0] + "/" + vars[1] + "#"
+	for key, comments := range s.comments {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for _, comment := range comments {
+			if comment.GetID() != id {
+				continue
+			}
+			if comment.GetUser().GetLogin() != Login {
+				writeJSON(w, http.StatusForbidden, map[string]string{"message": "Must have admin rights to Repository."})
+				return
+			}
+			edit := new(github.IssueComment)
+			if err := json.NewDecoder(r.Body).Decode(edit); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+				return
+			}
+			comment.Body = edit.Body
+			now := time.Now()
+			comment.UpdatedAt = &now
+			writeJSON(w, http.StatusOK, comment)
+			return
+		}
+	}
+	notFound(w)
+}
+
+func (s *Server) listStatuses(w http.ResponseWriter, r *http.Request, vars []string) {
+	statuses := s.statuses[refKey(vars[0], vars[1], vars[2])]
+	if statuses == nil {
+		statuses = []*github.RepoStatus{}
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *Server) createStatus(w http.ResponseWriter, r *http.Request, vars []string) {
+	status := new(github.RepoStatus)
+	if err := json.NewDecoder(r.Body).Decode(status); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	switch status.GetState() {
+	case "error", "failure", "pending", "success":
+	default:
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Validation Failed"})
+		return
+	}
+	status.ID = nil
+	s.addStatus(refKey(vars[0], vars[1], vars[2]), status)
+	writeJSON(w, http.StatusCreated, status)
+}
+
+func (s *Server) listCheckRuns(w http.ResponseWriter, r *http.Request, vars []string) {
+	runs := s.checkRuns[refKey(vars[0], vars[1], vars[2])]
+	if name := r.URL.Query().Get("check_name"); name != "" {
+		var named []*github.CheckRun
+		for _, run := range runs {
+			if run.GetName() == name {
+				named = append(named, run)
+			}
+		}
+		runs = named
+	}
+	if runs == nil {
+		runs = []*github.CheckRun{}
+	}
+	writeJSON(w, http.StatusOK, &github.ListCheckRunsResults{
+		Total:     github.Int(len(runs)),
+		CheckRuns: runs,
+	})
+}
+
+// checkRunRequest is the union of the create and update check run bodies.
+type checkRunRequest struct {
+	Name       string          `json:"name"`
+	HeadSHA    string          `json:"head_sha"`
+	ExternalID *string         `json:"external_id"`
+	Status     *string         `json:"status"`
+	Conclusion *string         `json:"conclusion"`
+	Output     *checkRunOutput `json:"output"`
+}
+
+// checkRunOutput is the output of a check run as the API accepts it.
+// go-github's CheckRunOutput uses older names for the annotation fields.
+type checkRunOutput struct {
+	Title       *string `json:"title"`
+	Summary     *string `json:"summary"`
+	Annotations []struct {
+		Path            string `json:"path"`
+		StartLine       int    `json:"start_line"`
+		EndLine         int    `json:"end_line"`
+		AnnotationLevel string `json:"annotation_level"`
+		Title           string `json:"title"`
+		Message         string `json:"message"`
+	} `json:"annotations"`
+}
+
+// output converts o to go-github's type, or returns nil if o is nil.
+func (o *checkRunOutput) output() *github.CheckRunOutput {
+	if o == nil {
+		return nil
+	}
+	out := &github.CheckRunOutput{Title: o.Title, Summary: o.Summary}
+	for _, a := range o.Annotations {
+		out.Annotations = append(out.Annotations, &github.CheckRunAnnotation{
+			FileName:     github.String(a.Path),
+			StartLine:    github.Int(a.StartLine),
+			EndLine:      github.Int(a.EndLine),
+			WarningLevel: github.String(a.AnnotationLevel),
+			Title:        github.String(a.Title),
+			Message:      github.String(a.Message),
+		})
+	}
+	return out
+}
+
+func (s *Server) createCheckRun(w http.ResponseWriter, r *http.Request, vars []string) {
+	req := new(checkRunRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	if req.Name == "" || req.HeadSHA == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Validation Failed"})
+		return
+	}
+	run := &github.CheckRun{
+		ID:         github.Int64(s.id()),
+		Name:       github.String(req.Name),
+		HeadSHA:    github.String(req.HeadSHA),
+		ExternalID: req.ExternalID,
+		Status:     req.Status,
+		Conclusion: req.Conclusion,
+		Output:     req.Output.output(),
+	}
+	key := refKey(vars[0], vars[1], req.HeadSHA)
+	s.checkRuns[key] = append(s.checkRuns[key], run)
+	writeJSON(w, http.StatusCreated, run)
+}
+
+func (s *Server) updateCheckRun(w http.ResponseWriter, r *http.Request, vars []string) {
+	id, err := strconv.ParseInt(vars[2], 10, 64)
+	if err != nil {
+		notFound(w)
+		return
+	}
+	req := new(checkRunRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	prefix := vars[0] + "/" + vars[1] + "@"
+	for key, runs := range s.checkRuns {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for _, run := range runs {
+			if run.GetID() != id {
+				continue
+			}
+			if req.Status != nil {
+				run.Status = req.Status
+			}
+			if req.Conclusion != nil {
+				run.Conclusion = req.Conclusion
+			}
+			if req.Output != nil {
+				run.Output = req.Output.output()
+			}
+			writeJSON(w, http.StatusOK, run)
+			return
+		}
+	}
+	notFound(w)
+}
+
+func (s *Server) getAuthenticatedUser(w http.ResponseWriter, r *http.Request, vars []string) {
+	writeJSON(w, http.StatusOK, &github.User{Login: github.String(Login)})
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request, vars []string) {
+	user, ok := s.users[strings.ToLower(vars[0])]
+	if !ok {
+		notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// userList returns logins as GitHub lists users.
+func userList(logins []string) []*github.User {
+	users := make([]*github.User, 0, len(logins))
+	for _, login := range logins {
+		users = append(users, &github.User{Login: github.String(login)})
+	}
+	return users
+}
+
+// contains reports whether logins contains login, ignoring case.
+func contains(logins []string, login string) bool {
+	for _, l := range logins {
+		if strings.EqualFold(l, login) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request, vars []string) {
+	writeJSON(w, http.StatusOK, userList(s.members[strings.ToLower(vars[0])]))
+}
+
+func (s *Server) checkMember(w http.ResponseWriter, r *http.Request, vars []string) {
+	if !contains(s.members[strings.ToLower(vars[0])], vars[1]) {
+		notFound(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listTeams(w http.ResponseWriter, r *http.Request, vars []string) {
+	teams := s.teams[strings.ToLower(vars[0])]
+	if teams == nil {
+		teams = []*github.Team{}
+	}
+	writeJSON(w, http.StatusOK, teams)
+}
+
+// team returns the members of the team with the ID in vars[0].
+func (s *Server) team(w http.ResponseWriter, vars []string) ([]string, bool) {
+	id, err := strconv.ParseInt(vars[0], 10, 64)
+	if err != nil {
+		notFound(w)
+		return nil, false
+	}
+	members, ok := s.teamUsers[id]
+	if !ok {
+		notFound(w)
+	}
+	return members, ok
+}
+
+func (s *Server) listTeamMembers(w http.ResponseWriter, r *http.Request, vars []string) {
+	if members, ok := s.team(w, vars); ok {
+		writeJSON(w, http.StatusOK, userList(members))
+	}
+}
+
+func (s *Server) getTeamMembership(w http.ResponseWriter, r *http.Request, vars []string) {
+	members, ok := s.team(w, vars)
+	if !ok {
+		return
+	}
+	if !contains(members, vars[1]) {
+		notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, &github.Membership{
+		State: github.String("active"),
+		Role:  github.String("member"),
+	})
+}
@@ -0,0 +1,54 @@
+package maintainerbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mutationLogSuffix is the extension maintner's DiskMutationLogger uses for
+// its log files.
+const mutationLogSuffix = ".mutlog"
+
+// statMutationLogs returns the size of every mutation log in dir.
+func statMutationLogs(dir string) (map[string]int64, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return map[string]int64{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64)
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), mutationLogSuffix) {
+			sizes[fi.Name()] = fi.Size()
+		}
+	}
+	return sizes, nil
+}
+
+// rollbackMutationLogs restores the mutation logs in dir to the sizes in
+// before, a result of statMutationLogs. Logs that didn't exist are removed,
+// and logs that grew are truncated.
+func rollbackMutationLogs(dir string, before map[string]int64) error {
+	after, err := statMutationLogs(dir)
+	if err != nil {
+		return err
+	}
+	for name, size := range after {
+		path := filepath.Join(dir, name)
+		oldSize, existed := before[name]
+		switch {
+		case !existed:
+			err = os.Remove(path)
+		case size > oldSize:
+			err = os.Truncate(path, oldSize)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package maintainerbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRollbackMutationLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintainerbot-mutlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, contents string) {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(contents)
+		f.Close()
+	}
+	write("maintner-2018-10-09.mutlog", "complete")
+	write("maintner-2018-10-10.mutlog", "before")
+	write("state.jsonl", "{}\n")
+	before, err := statMutationLogs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A sync that fails halfway through.
+	write("maintner-2018-10-10.mutlog", "partial")
+	write("maintner-2018-10-11.mutlog", "partial")
+	write("state.jsonl", "{}\n")
+	if err := rollbackMutationLogs(dir, before); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"maintner-2018-10-09.mutlog": "complete",
+		"maintner-2018-10-10.mutlog": "before",
+		"state.jsonl":                "{}\n{}\n",
+	}
+	fis, _ := ioutil.ReadDir(dir)
+	if len(fis) != len(want) {
+		t.Errorf("got %d files, want %d", len(fis), len(want))
+	}
+	for name, contents := range want {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != contents {
+			t.Errorf("%s: got %q, want %q", name, data, contents)
+		}
+	}
+}
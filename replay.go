@@ -0,0 +1,260 @@
+package maintainerbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/google/go-github/github"
+	"golang.org/x/build/maintner"
+	"golang.org/x/build/maintner/maintpb"
+	"golang.org/x/build/maintner/reclog"
+)
+
+// mutationLogDay matches the date in the name of a mutation log.
+// DiskMutationLogger starts a new log every day (UTC).
+var mutationLogDay = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+
+// Snapshot copies the mutation logs in dataDir, usually a Bot's DataDir, to
+// dst, leaving out every change GitHub reports as happening after until. The
+// result is a frozen copy of the corpus, as the Bot saw it at that time, that
+// can be passed to Replay.
+//
+// Mutations are filtered by the issue and comment timestamps in them; a
+// mutation without a timestamp is kept if it was logged on or before the day
+// of until.
+func Snapshot(dataDir, dst string, until time.Time) error {
+	logs, err := statMutationLogs(dataDir)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf("snapshot: no mutation logs in %s", dataDir)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	lastDay := until.UTC().Format("2006-01-02")
+	for name := range logs {
+		src, out := filepath.Join(dataDir, name), filepath.Join(dst, name)
+		if err := os.Remove(out); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		day := mutationLogDay.FindString(name)
+		switch {
+		case day != "" && day < lastDay:
+			err = copyFile(src, out)
+		case day > lastDay:
+			// Logged after until; nothing in it can be older.
+		default:
+			err = filterMutationLog(src, out, until)
+		}
+		if err != nil {
+			return fmt.Errorf("snapshot: %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// filterMutationLog copies the records in src that happened no later than
+// until to dst.
+func filterMutationLog(src, dst string, until time.Time) error {
+	return reclog.ForeachFileRecord(src, func(off int64, hdr, rec []byte) error {
+		m := new(maintpb.Mutation)
+		if err := proto.Unmarshal(rec, m); err != nil {
+			return fmt.Errorf("record at offset %d: %v", off, err)
+		}
+		if t, ok := mutationTime(m); ok && t.After(until) {
+			return nil
+		}
+		return reclog.AppendRecordToFile(dst, rec)
+	})
+}
+
+// mutationTime returns the latest time reported by GitHub in m.
+func mutationTime(m *maintpb.Mutation) (time.Time, bool) {
+	var latest time.Time
+	found := false
+	see := func(ts *timestamp.Timestamp) {
+		if ts == nil {
+			return
+		}
+		t, err := ptypes.Timestamp(ts)
+		if err != nil {
+			return
+		}
+		if !found || t.After(latest) {
+			latest = t
+			found = true
+		}
+	}
+	if im := m.GithubIssue; im != nil {
+		see(im.Created)
+		see(im.Updated)
+		see(im.ClosedAt)
+		for _, c := range im.Comment {
+			see(c.Created)
+			see(c.Updated)
+		}
+	}
+	return latest, found
+}
+
+// Replay loads the corpus in dir, usually a copy made by Snapshot, and runs
+// the Task returned by newTask once against every repository in it, as the
+// Bot would on its first run. The Task's GitHub client is in dry-run mode:
+// reads are sent to GitHub with token, and writes are captured and returned
+// instead of being made. opts are passed on to NewGitHubClient.
+//
+// Because the Task talks to the live GitHub API for reads, pull request
+// details and statuses reflect GitHub today, not the time of the snapshot. Use
+// BaseURL to point the client at a fake server for fully reproducible runs.
+func Replay(ctx context.Context, dir, token string, newTask func(ghc *github.Client) Task, opts ...ClientOption) ([]PlannedAction, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	corpus := new(maintner.Corpus)
+	if err := corpus.Initialize(ctx, maintner.NewDiskMutationLogger(dir)); err != nil {
+		return nil, fmt.Errorf("replay: loading corpus from %s: %v", dir, err)
+	}
+	var repos []*maintner.GitHubRepo
+	corpus.GitHub().ForeachRepo(func(repo *maintner.GitHubRepo) error {
+		repos = append(repos, repo)
+		return nil
+	})
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].ID().String() < repos[j].ID().String()
+	})
+
+	rec := NewRecorder()
+	opts = append([]ClientOption{DryRun(rec)}, opts...)
+	e := &taskEntry{task: newTask(NewGitHubClient(token, 0, opts...))}
+	if c, ok := e.task.(io.Closer); ok {
+		defer c.Close()
+	}
+	var errs repoErrors
+	for _, repo := range repos {
+		if !e.wantRepo(repo.ID()) {
+			continue
+		}
+		if err := e.do(ctx, repo); err != nil {
+			errs = append(errs, fmt.Errorf("%T on %s: %v", e.task, repo.ID(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return rec.Actions(), errs
+	}
+	return rec.Actions(), nil
+}
+
+// ReadActions reads planned actions written by Recorder.WriteJSON or
+// Bot.DryRunFile.
+func ReadActions(r io.Reader) ([]PlannedAction, error) {
+	var actions []PlannedAction
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return actions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] == '[' {
+			var list []PlannedAction
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, err
+			}
+			actions = append(actions, list...)
+			continue
+		}
+		var a PlannedAction
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+}
+
+// ReadActionsFile is like ReadActions, but reads from the named file.
+func ReadActionsFile(name string) ([]PlannedAction, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ReadActions(bytes.NewReader(data))
+}
+
+// DiffActions compares the actions planned by two runs, for example Replay
+// runs of two versions of a Task. It ignores the time and order of the
+// actions, and returns the ones only old planned and the ones only new
+// planned, in their original order.
+func DiffActions(old, new []PlannedAction) (removed, added []PlannedAction) {
+	count := make(map[string]int)
+	for _, a := range old {
+		count[a.key()]++
+	}
+	for _, a := range new {
+		k := a.key()
+		if count[k] > 0 {
+			count[k]--
+			continue
+		}
+		added = append(added, a)
+	}
+	for _, a := range old {
+		k := a.key()
+		if count[k] > 0 {
+			count[k]--
+			removed = append(removed, a)
+		}
+	}
+	return removed, added
+}
+
+// key identifies an action for DiffActions. The body is re-encoded so that
+// field order and whitespace don't matter.
+func (a PlannedAction) key() string {
+	body := []byte(a.Body)
+	var v interface{}
+	if json.Unmarshal(a.Body, &v) == nil {
+		body, _ = json.Marshal(v)
+	}
+	return a.Method + " " + a.Path + " " + string(body)
+}
+
+func (a PlannedAction) String() string {
+	if len(a.Body) == 0 {
+		return a.Method + " " + a.Path
+	}
+	return a.Method + " " + a.Path + " " + string(a.Body)
+}
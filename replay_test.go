@@ -0,0 +1,114 @@
+package maintainerbot
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/go-github/github"
+	"golang.org/x/build/maintner"
+	"golang.org/x/build/maintner/maintpb"
+	"golang.org/x/build/maintner/reclog"
+)
+
+func logIssue(t *testing.T, dir string, number int32, updated time.Time) {
+	t.Helper()
+	ts, err := ptypes.TimestampProto(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := proto.Marshal(&maintpb.Mutation{GithubIssue: &maintpb.GithubIssueMutation{
+		Owner:       "sourcegraph",
+		Repo:        "sourcegraph",
+		Number:      number,
+		Id:          int64(number),
+		User:        &maintpb.GithubUser{Id: 1, Login: "kevinburke"},
+		Created:     ts,
+		Updated:     ts,
+		PullRequest: true,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "maintner-"+updated.UTC().Format("2006-01-02")+mutationLogSuffix)
+	if err := reclog.AppendRecordToFile(name, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintainerbot-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dataDir, snapDir := filepath.Join(dir, "data"), filepath.Join(dir, "snapshot")
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	logIssue(t, dataDir, 1, day.Add(9*time.Hour))
+	logIssue(t, dataDir, 2, day.Add(33*time.Hour))
+	logIssue(t, dataDir, 3, day.Add(35*time.Hour))
+	logIssue(t, dataDir, 4, day.Add(60*time.Hour))
+
+	if err := Snapshot(dataDir, snapDir, day.Add(34*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	newTask := func(ghc *github.Client) Task {
+		return funcTask(func(ctx context.Context, repo *maintner.GitHubRepo) error {
+			return repo.ForeachIssue(func(gi *maintner.GitHubIssue) error {
+				_, _, err := ghc.Issues.CreateComment(ctx, repo.ID().Owner, repo.ID().Repo, int(gi.Number), &github.IssueComment{
+					Body: github.String("hello"),
+				})
+				return err
+			})
+		})
+	}
+	actions, err := Replay(context.Background(), snapDir, "token", newTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, a := range actions {
+		paths = append(paths, a.Path)
+	}
+	got := strings.Join(paths, ",")
+	want := "/repos/sourcegraph/sourcegraph/issues/1/comments,/repos/sourcegraph/sourcegraph/issues/2/comments"
+	if got != want {
+		t.Errorf("replay planned comments on\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDiffActions(t *testing.T) {
+	action := func(method, path, body string) PlannedAction {
+		return PlannedAction{Time: time.Now(), Method: method, Path: path, Body: json.RawMessage(body)}
+	}
+	old := []PlannedAction{
+		action("POST", "/repos/a/b/statuses/1", `{"state":"failure","context":"cla-bot"}`),
+		action("POST", "/repos/a/b/issues/2/labels", `["new-contributor"]`),
+		action("POST", "/repos/a/b/issues/2/comments", `{"body":"hi"}`),
+	}
+	new := []PlannedAction{
+		action("POST", "/repos/a/b/issues/2/labels", `["new-contributor"]`),
+		action("POST", "/repos/a/b/statuses/1", `{"context": "cla-bot", "state": "failure"}`),
+		action("POST", "/repos/a/b/issues/2/comments", `{"body":"hello"}`),
+	}
+	removed, added := DiffActions(old, new)
+	if len(removed) != 1 || removed[0].String() != `POST /repos/a/b/issues/2/comments {"body":"hi"}` {
+		t.Errorf("wrong removed actions: %v", removed)
+	}
+	if len(added) != 1 || added[0].String() != `POST /repos/a/b/issues/2/comments {"body":"hello"}` {
+		t.Errorf("wrong added actions: %v", added)
+	}
+	if removed, added := DiffActions(old, old); len(removed) != 0 || len(added) != 0 {
+		t.Errorf("diff of identical runs: removed %v, added %v", removed, added)
+	}
+}
@@ -0,0 +1,248 @@
+package maintainerbot
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures the Retry client option.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, including the first
+	// attempt. Defaults to 4.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the exponential backoff between
+	// attempts. The actual wait is chosen at random, up to the backoff for
+	// that attempt. They default to 500ms and 30 seconds.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// SafeToRetry reports whether a request that isn't idempotent can be
+	// sent again after it may have reached GitHub. If nil, posting a commit
+	// status and adding labels are considered safe, since doing them twice
+	// has the same result as doing them once.
+	SafeToRetry func(*http.Request) bool
+	// If Metrics is set, it counts the requests the client retries.
+	Metrics *RetryMetrics
+}
+
+// Retry makes the client retry requests that fail with a transient error: a
+// timeout, a dropped connection, or a 500, 502, 503 or 504 response. Reads,
+// and other idempotent requests, are always retried; writes only if they
+// never reached GitHub or p.SafeToRetry allows it. The client gives up early
+// if the request's context would expire before the next attempt.
+//
+// Rate limit errors are not retried here; use a Budget to wait them out.
+func Retry(p RetryPolicy) ClientOption {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 4
+	}
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = 500 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 30 * time.Second
+	}
+	if p.SafeToRetry == nil {
+		p.SafeToRetry = safeToRetry
+	}
+	return func(c *clientConfig) {
+		c.retry = &p
+	}
+}
+
+// safeToRetry is the default RetryPolicy.SafeToRetry.
+func safeToRetry(r *http.Request) bool {
+	if r.Method != "POST" {
+		return false
+	}
+	// POST /repos/:owner/:repo/statuses/:sha replaces the status for the
+	// same context, and POST .../issues/:number/labels adds to a set.
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	n := len(parts)
+	return (n >= 2 && parts[n-2] == "statuses") || (n >= 1 && parts[n-1] == "labels")
+}
+
+// RetryMetrics counts the requests retried by clients using Retry. The same
+// RetryMetrics can be shared by several clients. It is safe for concurrent
+// use.
+type RetryMetrics struct {
+	mu    sync.Mutex
+	stats RetryStats
+}
+
+// RetryStats is a snapshot of a RetryMetrics.
+type RetryStats struct {
+	// Requests is the number of requests sent, not counting retries.
+	Requests int64
+	// Retried is the number of requests that were retried at least once.
+	Retried int64
+	// Retries is the total number of retries.
+	Retries int64
+	// GaveUp is the number of requests that still failed after being
+	// retried.
+	GaveUp int64
+	// Reasons counts retries by cause, for example "502" or "timeout".
+	Reasons map[string]int64
+}
+
+// Stats returns the current counts.
+func (m *RetryMetrics) Stats() RetryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats
+	s.Reasons = make(map[string]int64, len(m.stats.Reasons))
+	for k, v := range m.stats.Reasons {
+		s.Reasons[k] = v
+	}
+	return s
+}
+
+func (m *RetryMetrics) record(retries int, gaveUp bool, reasons []string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.Requests++
+	if retries == 0 {
+		return
+	}
+	m.stats.Retried++
+	m.stats.Retries += int64(retries)
+	if gaveUp {
+		m.stats.GaveUp++
+	}
+	if m.stats.Reasons == nil {
+		m.stats.Reasons = make(map[string]int64)
+	}
+	for _, reason := range reasons {
+		m.stats.Reasons[reason]++
+	}
+}
+
+type retryTransport struct {
+	policy *RetryPolicy
+	base   http.RoundTripper
+}
+
+func (t retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var reasons []string
+	req := r
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && r.Body != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			// RoundTrippers must not modify the request they are given.
+			req = new(http.Request)
+			*req = *r
+			req.Body = body
+		}
+		resp, err := t.base.RoundTrip(req)
+		reason := retryReason(resp, err)
+		if r.Context().Err() != nil {
+			reason = ""
+		}
+		if reason == "" || attempt == t.policy.MaxAttempts || !t.canRetry(r, err) {
+			t.policy.Metrics.record(attempt-1, reason != "", reasons)
+			return resp, err
+		}
+		wait := t.backoff(attempt)
+		if deadline, ok := r.Context().Deadline(); ok && time.Until(deadline) < wait {
+			t.policy.Metrics.record(attempt-1, true, reasons)
+			return resp, err
+		}
+		if resp != nil {
+			// Drain the body so the connection can be reused.
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		reasons = append(reasons, reason)
+		select {
+		case <-r.Context().Done():
+			t.policy.Metrics.record(attempt-1, true, reasons)
+			return nil, r.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// canRetry reports whether r can be sent again after it failed with err.
+func (t retryTransport) canRetry(r *http.Request, err error) bool {
+	if r.Body != nil && r.GetBody == nil {
+		return false
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return notSent(err) || t.policy.SafeToRetry(r)
+}
+
+// backoff returns how long to wait before the attempt after attempt, using
+// "full jitter" so that many clients failing at once don't retry in step.
+func (t retryTransport) backoff(attempt int) time.Duration {
+	max := t.policy.MinBackoff << uint(attempt-1)
+	if max > t.policy.MaxBackoff || max <= 0 {
+		max = t.policy.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(max)) + 1)
+}
+
+// retryReason returns why a request that got resp and err should be retried,
+// or "" if it shouldn't be.
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return "timeout"
+		}
+		switch unwrapError(err) {
+		case syscall.ECONNRESET:
+			return "connection reset"
+		case syscall.ECONNREFUSED:
+			return "connection refused"
+		case io.ErrUnexpectedEOF, io.EOF:
+			return "unexpected EOF"
+		}
+		return ""
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return strconv.Itoa(resp.StatusCode)
+	}
+	return ""
+}
+
+// notSent reports whether err means the request never reached the server.
+func notSent(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+		return true
+	}
+	return unwrapError(err) == syscall.ECONNREFUSED
+}
+
+// unwrapError returns the error underneath the network errors wrapping err.
+func unwrapError(err error) error {
+	for {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			return err
+		}
+	}
+}
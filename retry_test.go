@@ -0,0 +1,113 @@
+package maintainerbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// flakyServer fails the first failures requests to each path with a 502.
+func flakyServer(failures int) (*httptest.Server, func(path string) int) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		counts[r.URL.Path]++
+		n := counts[r.URL.Path]
+		mu.Unlock()
+		if n <= failures {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	return s, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[path]
+	}
+}
+
+func TestRetry(t *testing.T) {
+	s, requests := flakyServer(2)
+	defer s.Close()
+	metrics := new(RetryMetrics)
+	ghc := NewGitHubClient("token", time.Nanosecond, BaseURL(s.URL), Retry(RetryPolicy{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		Metrics:    metrics,
+	}))
+	ctx := context.Background()
+
+	if _, _, err := ghc.PullRequests.Get(ctx, "sourcegraph", "sourcegraph", 1); err != nil {
+		t.Fatalf("read wasn't retried: %v", err)
+	}
+	if n := requests("/repos/sourcegraph/sourcegraph/pulls/1"); n != 3 {
+		t.Errorf("read: got %d requests, want 3", n)
+	}
+	_, _, err := ghc.Repositories.CreateStatus(ctx, "sourcegraph", "sourcegraph", "abc123", &github.RepoStatus{
+		State: github.String("success"),
+	})
+	if err != nil {
+		t.Fatalf("status wasn't retried: %v", err)
+	}
+	_, _, err = ghc.Issues.CreateComment(ctx, "sourcegraph", "sourcegraph", 1, &github.IssueComment{
+		Body: github.String("hello"),
+	})
+	if err == nil {
+		t.Fatal("comment was retried, which could post it twice")
+	}
+	if n := requests("/repos/sourcegraph/sourcegraph/issues/1/comments"); n != 1 {
+		t.Errorf("comment: got %d requests, want 1", n)
+	}
+
+	stats := metrics.Stats()
+	if stats.Requests != 3 || stats.Retried != 2 || stats.Retries != 4 || stats.GaveUp != 0 {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+	if stats.Reasons["502"] != 4 {
+		t.Errorf("got %d retries for 502, want 4", stats.Reasons["502"])
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	s, requests := flakyServer(100)
+	defer s.Close()
+	metrics := new(RetryMetrics)
+	ghc := NewGitHubClient("token", time.Nanosecond, BaseURL(s.URL), Retry(RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+		Metrics:     metrics,
+	}))
+	if _, _, err := ghc.PullRequests.Get(context.Background(), "sourcegraph", "sourcegraph", 1); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := requests("/repos/sourcegraph/sourcegraph/pulls/1"); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+	if stats := metrics.Stats(); stats.GaveUp != 1 {
+		t.Errorf("got %d requests given up, want 1", stats.GaveUp)
+	}
+
+	// A request whose deadline is closer than the next backoff isn't
+	// retried.
+	ghc = NewGitHubClient("token", time.Nanosecond, BaseURL(s.URL), Retry(RetryPolicy{
+		MinBackoff: time.Hour,
+		MaxBackoff: time.Hour,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := ghc.PullRequests.Get(ctx, "sourcegraph", "sourcegraph", 2); err == nil {
+		t.Fatal("expected an error")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("waited for a retry that couldn't finish before the deadline")
+	}
+}
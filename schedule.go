@@ -0,0 +1,320 @@
+package maintainerbot
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/build/maintner"
+	"golang.org/x/build/maintner/maintpb"
+)
+
+// A Schedule decides when a Task should next run.
+type Schedule interface {
+	// Next returns the first time after t that the Task should run. A zero
+	// Time means the Task should never run again.
+	Next(t time.Time) time.Time
+}
+
+// Every runs a Task at most once per interval d, starting with the first
+// polling cycle after the Bot starts. Because the Bot only checks for due
+// tasks once per polling cycle (every 15 seconds), intervals shorter than
+// that behave as if no schedule was set.
+func Every(d time.Duration) TaskOption {
+	return OnSchedule(intervalSchedule(d))
+}
+
+// Cron runs a Task on a cron schedule. spec is a standard five field cron
+// expression ("minute hour day-of-month month day-of-week"), or one of
+// "@hourly", "@daily", "@weekly", "@monthly" or "@yearly". Times are matched
+// in the local time zone. The Task first runs at the first matching time
+// after it is registered, so restarting the Bot doesn't run it early. Cron
+// panics if spec is invalid; call ParseCron first if the spec comes from
+// user input.
+func Cron(spec string) TaskOption {
+	sched, err := ParseCron(spec)
+	if err != nil {
+		panic(err)
+	}
+	return OnSchedule(sched)
+}
+
+// OnSchedule runs a Task according to sched, first at sched.Next of the time
+// it is registered. Without a Schedule, a Task runs on every polling cycle.
+func OnSchedule(sched Schedule) TaskOption {
+	return func(e *taskEntry) {
+		e.schedule = sched
+	}
+}
+
+// Jitter delays each scheduled run by a random duration up to d, so that
+// tasks with the same schedule don't all hit GitHub at once.
+func Jitter(d time.Duration) TaskOption {
+	return func(e *taskEntry) {
+		e.jitter = d
+	}
+}
+
+// OnlyOnChange skips a Task if the corpus has not changed since the last
+// time the Task ran. It can be combined with a Schedule, in which case the
+// Task runs when it is due and the corpus has changed.
+func OnlyOnChange() TaskOption {
+	return func(e *taskEntry) {
+		e.onlyOnChange = true
+	}
+}
+
+// TaskStatus reports when a registered Task last ran and when it will run
+// next.
+type TaskStatus struct {
+	// Name is the type of the Task, for example "*tasks.CLAChecker".
+	Name string
+	// LastRun is the last time the Task started running, or the zero Time if
+	// it has not run yet.
+	LastRun time.Time
+	// NextRun is the earliest time the Task will run again. A zero Time
+	// means the Task runs on the next polling cycle.
+	NextRun time.Time
+	// Runs is the number of times the Task has run, and Failures is how
+	// many of those runs returned an error or panicked.
+	Runs, Failures int
+	// ConsecutiveFailures is the number of runs that have failed since the
+	// last successful run. While it is non-zero, the Task is backing off.
+	ConsecutiveFailures int
+	// LastError is the error from the most recent failed run, if any.
+	LastError error
+}
+
+// TaskStatus returns the schedule status of every registered Task, in the
+// order they were registered.
+func (b *Bot) TaskStatus() []TaskStatus {
+	b.statusMu.Lock()
+	defer b.statusMu.Unlock()
+	statuses := make([]TaskStatus, len(b.tasks))
+	for i, e := range b.tasks {
+		statuses[i] = TaskStatus{
+			Name:                fmt.Sprintf("%T", e.task),
+			LastRun:             e.lastRun,
+			NextRun:             e.nextRun,
+			Runs:                e.runs,
+			Failures:            e.failures,
+			ConsecutiveFailures: e.consecutive,
+			LastError:           e.lastErr,
+		}
+	}
+	return statuses
+}
+
+// due reports whether e should run at now, given the current corpus
+// generation. The caller must hold b.statusMu.
+func (e *taskEntry) due(now time.Time, generation uint64) bool {
+	if now.Before(e.nextRun) {
+		return false
+	}
+	if e.onlyOnChange && !e.lastRun.IsZero() && e.generation == generation {
+		return false
+	}
+	return true
+}
+
+// ran records that e started running at now. The caller must hold
+// b.statusMu.
+func (e *taskEntry) ran(now time.Time, generation uint64) {
+	e.lastRun = now
+	e.generation = generation
+	e.scheduleNext(now)
+}
+
+// scheduleFirst sets when e first runs, once it is registered at now.
+// Intervals start on the first polling cycle; other schedules wait for the
+// first time they match.
+func (e *taskEntry) scheduleFirst(now time.Time) {
+	if _, ok := e.schedule.(intervalSchedule); ok {
+		return
+	}
+	e.scheduleNext(now)
+}
+
+// scheduleNext sets when e runs next after now, according to its schedule.
+func (e *taskEntry) scheduleNext(now time.Time) {
+	if e.schedule == nil {
+		return
+	}
+	e.nextRun = e.schedule.Next(now)
+	if e.nextRun.IsZero() {
+		// Never run again.
+		e.nextRun = time.Unix(1<<62, 0)
+		return
+	}
+	if e.jitter > 0 {
+		e.nextRun = e.nextRun.Add(time.Duration(rand.Int63n(int64(e.jitter))))
+	}
+}
+
+type intervalSchedule time.Duration
+
+func (d intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(d))
+}
+
+// countingLogger wraps a DiskMutationLogger and counts the mutations written
+// to it, so the Bot can tell whether a Sync changed the corpus.
+type countingLogger struct {
+	*maintner.DiskMutationLogger
+	count uint64
+}
+
+func (l *countingLogger) Log(m *maintpb.Mutation) error {
+	atomic.AddUint64(&l.count, 1)
+	return l.DiskMutationLogger.Log(m)
+}
+
+func (l *countingLogger) generation() uint64 {
+	if l == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&l.count)
+}
+
+// CronSchedule is a Schedule parsed from a cron expression.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set when the day-of-month or day-of-week field
+	// is "*". Per cron convention, if both fields are restricted, a day
+	// matches if either of them matches.
+	domStar, dowStar bool
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression. See Cron for the supported syntax.
+func ParseCron(spec string) (*CronSchedule, error) {
+	expr := strings.TrimSpace(spec)
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec %q: expected 5 fields, got %d", spec, len(fields))
+	}
+	c := new(CronSchedule)
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("cron spec %q: minute: %v", spec, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("cron spec %q: hour: %v", spec, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("cron spec %q: day of month: %v", spec, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("cron spec %q: month: %v", spec, err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("cron spec %q: day of week: %v", spec, err)
+	}
+	// 7 is an alias for Sunday.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges ("1-5"),
+// wildcards and steps ("*/15", "0-30/10") into a bit set.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.IndexByte(rangePart, '-') >= 0:
+			i := strings.IndexByte(rangePart, '-')
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(rangePart[:i])
+			hi, err2 = strconv.Atoi(rangePart[i+1:])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range [%d, %d]", part, min, max)
+		}
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dowMatch
+	case c.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// Next returns the first minute after t that matches the schedule, or the
+// zero Time if nothing matches in the next five years (for example, "0 0 31 2
+// *").
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,86 @@
+package maintainerbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday.
+	base := time.Date(2018, time.October, 10, 9, 30, 15, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2018, time.October, 10, 9, 31, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2018, time.October, 10, 10, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2018, time.October, 10, 9, 45, 0, 0, time.UTC)},
+		{"0 9 * * 1", time.Date(2018, time.October, 15, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2018, time.October, 14, 9, 0, 0, 0, time.UTC)},
+		{"30 2 1 * *", time.Date(2018, time.November, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 13 * 5", time.Date(2018, time.October, 12, 12, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := c.Next(base); !got.Equal(tt.want) {
+			t.Errorf("Next(%q): got %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q): expected error, got nil", spec)
+		}
+	}
+}
+
+func TestTaskDue(t *testing.T) {
+	now := time.Date(2018, time.October, 10, 9, 30, 0, 0, time.UTC)
+	e := &taskEntry{schedule: intervalSchedule(time.Hour), onlyOnChange: true}
+	if !e.due(now, 1) {
+		t.Fatal("task should be due before its first run")
+	}
+	e.ran(now, 1)
+	if e.due(now.Add(30*time.Minute), 2) {
+		t.Error("task should not be due before its interval elapses")
+	}
+	if e.due(now.Add(2*time.Hour), 1) {
+		t.Error("task should not be due when the corpus has not changed")
+	}
+	if !e.due(now.Add(2*time.Hour), 2) {
+		t.Error("task should be due after its interval when the corpus changed")
+	}
+}
+
+func TestTaskFirstRun(t *testing.T) {
+	now := time.Date(2018, time.October, 10, 9, 30, 0, 0, time.UTC)
+	weekly, err := ParseCron("0 9 * * 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &taskEntry{schedule: weekly}
+	e.scheduleFirst(now)
+	if e.due(now, 1) {
+		t.Error("cron task is due as soon as it is registered")
+	}
+	if e.due(time.Date(2018, time.October, 15, 8, 59, 0, 0, time.UTC), 1) {
+		t.Error("cron task is due before its first matching time")
+	}
+	if !e.due(time.Date(2018, time.October, 15, 9, 0, 0, 0, time.UTC), 1) {
+		t.Error("cron task is not due at its first matching time")
+	}
+
+	e = &taskEntry{schedule: intervalSchedule(time.Hour)}
+	e.scheduleFirst(now)
+	if !e.due(now, 1) {
+		t.Error("interval task should run on the first polling cycle")
+	}
+}
@@ -11,15 +11,23 @@
 // bot.RegisterTask(). Finally, bot.Run() will run the tasks in a loop, calling
 // each Task periodically. The Task can do whatever it needs to do to update the
 // repository as it sees fit.
+//
+// A single Bot can watch several repositories, or every repository in an
+// organization, with bot.WatchRepo() and bot.WatchOrg(). All of the
+// repositories share one corpus and one rate limit, and each Task is called
+// once per repository.
 package maintainerbot
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -34,104 +42,507 @@ type Task interface {
 	Do(ctx context.Context, repo *maintner.GitHubRepo) error
 }
 
+// RepoFilter can be implemented by a Task that only wants to run against some
+// of the repositories watched by the Bot. If a Task implements RepoFilter, Do
+// is only called for repositories where WantRepo returns true.
+type RepoFilter interface {
+	WantRepo(id maintner.GitHubRepoID) bool
+}
+
+// TaskOption configures how the Bot runs a registered Task.
+type TaskOption func(*taskEntry)
+
+// OnlyRepos restricts a Task to the given repositories, in "owner/repo"
+// format. A bare owner (or "owner/*") matches every repository in that
+// organization.
+func OnlyRepos(repos ...string) TaskOption {
+	return func(e *taskEntry) {
+		e.only = append(e.only, repos...)
+	}
+}
+
+// SkipRepos prevents a Task from running against the given repositories, in
+// the same format as OnlyRepos.
+func SkipRepos(repos ...string) TaskOption {
+	return func(e *taskEntry) {
+		e.skip = append(e.skip, repos...)
+	}
+}
+
+type taskEntry struct {
+	task Task
+	only []string
+	skip []string
+
+	schedule     Schedule
+	jitter       time.Duration
+	onlyOnChange bool
+	timeout      time.Duration
+	alone        bool
+
+	// Guarded by Bot.statusMu.
+	lastRun     time.Time
+	nextRun     time.Time
+	generation  uint64
+	runs        int
+	failures    int
+	consecutive int
+	lastErr     error
+
+	// Guarded by Bot.eventMu. Only used for EventTasks.
+	caughtUp map[maintner.GitHubRepoID]bool
+	pending  map[maintner.GitHubRepoID][]Event
+}
+
+// wantRepo reports whether the task should run against the repo with the
+// given ID.
+func (e *taskEntry) wantRepo(id maintner.GitHubRepoID) bool {
+	if len(e.only) > 0 && !matchRepo(e.only, id) {
+		return false
+	}
+	if matchRepo(e.skip, id) {
+		return false
+	}
+	if f, ok := e.task.(RepoFilter); ok {
+		return f.WantRepo(id)
+	}
+	return true
+}
+
+func matchRepo(patterns []string, id maintner.GitHubRepoID) bool {
+	for _, p := range patterns {
+		spec := parseRepoSpec(p)
+		if spec.owner == id.Owner && (spec.repo == "" || spec.repo == id.Repo) {
+			return true
+		}
+	}
+	return false
+}
+
+// repoSpec identifies a repository to watch. An empty repo means every
+// repository owned by owner.
+type repoSpec struct {
+	owner, repo string
+}
+
+func parseRepoSpec(s string) repoSpec {
+	parts := strings.SplitN(strings.Trim(s, "/"), "/", 2)
+	if len(parts) == 1 || parts[1] == "*" {
+		return repoSpec{owner: parts[0]}
+	}
+	return repoSpec{owner: parts[0], repo: parts[1]}
+}
+
 type Bot struct {
 	// Directory for caching local data about issues and pull requests.
 	// Defaults to $HOME/var/maintainerbot.
 	DataDir string
 	// Interval between queries to send to GitHub. Defaults to 720ms, which
-	// works out to 5000 queries per hour.
+	// works out to 5000 queries per hour. The budget is shared between all
+	// of the watched repositories. Ignored if Budget is set.
 	GitHubRateLimit time.Duration
+	// If TokenSource is set, the Bot authenticates with tokens from it,
+	// for example an AppTokenSource, instead of the token passed to New.
+	// maintner only accepts a fixed token, so the Bot reloads the corpus
+	// whenever the token changes.
+	TokenSource oauth2.TokenSource
+	// Budget shares the GitHub rate limit between the corpus sync, which
+	// uses it at Background priority, and the clients of your tasks; pass
+	// the same Budget to NewGitHubClient with the UseBudget option. If nil,
+	// the Bot creates a Budget from GitHubRateLimit for the corpus alone.
+	Budget *Budget
+	// Maximum number of tasks to run at the same time. The default, 0, runs
+	// tasks one after another in the order they were registered. Tasks
+	// registered with RunAlone never overlap with other tasks. Tasks that
+	// run concurrently must not share unsynchronized state.
+	Concurrency int
+	// Recorder puts the Bot in dry-run mode. Pass the same Recorder to
+	// NewGitHubClient with the DryRun option when creating clients for your
+	// tasks; after every run the Bot logs the writes the tasks would have
+	// made.
+	Recorder *Recorder
+	// If DryRunFile is set, the writes captured by Recorder are also
+	// appended to this file as JSON, one action per line.
+	DryRunFile string
+	// How long Run waits for running tasks to finish after its context is
+	// canceled, before canceling the tasks' context. Defaults to 30 seconds.
+	ShutdownTimeout time.Duration
+
+	corpus      *maintner.Corpus
+	logger      *countingLogger
+	repos       []*maintner.GitHubRepo
+	budgetOnce  sync.Once
+	lastRefresh time.Time
+
+	watched     []repoSpec
+	token       string
+	corpusToken string
+
+	tasks    []*taskEntry
+	taskMu   sync.Mutex
+	statusMu sync.Mutex
+
+	wakeup   chan struct{}
+	wakeOnce sync.Once
 
-	corpus *maintner.Corpus
-	repo   *maintner.GitHubRepo
+	snapshots map[maintner.GitHubRepoID]repoSnapshot
+	eventMu   sync.Mutex
 
-	owner, repoName, token string
+	state   *StateStore
+	stateMu sync.Mutex
 
-	tasks  []Task
-	taskMu sync.Mutex
+	// inflight tracks running tasks and webhook handlers. Once closing is
+	// set, no webhook handlers are started, so shutdown can wait for
+	// inflight; both are guarded by inflightMu.
+	inflight    sync.WaitGroup
+	inflightMu  sync.Mutex
+	closing     bool
+	taskCtx     context.Context
+	cancelTasks context.CancelFunc
+	taskCtxOnce sync.Once
 }
 
 // RegisterTask registers t with the bot. When the Bot is running, t will be
-// called periodically with the latest repo contents.
-func (b *Bot) RegisterTask(t Task) {
-	b.tasks = append(b.tasks, t)
+// called periodically with the latest contents of each watched repository.
+// By default t runs on every polling cycle; use Every, Cron, Jitter and
+// OnlyOnChange to run it less often.
+func (b *Bot) RegisterTask(t Task, opts ...TaskOption) {
+	e := &taskEntry{task: t}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.scheduleFirst(time.Now())
+	b.tasks = append(b.tasks, e)
 }
 
-// New creates a new Bot.
+// New creates a new Bot that watches the owner/repo repository. If repo is
+// empty, the Bot watches every repository owned by owner. Call WatchRepo or
+// WatchOrg to watch additional repositories with the same Bot.
 func New(owner, repo, token string) *Bot {
-	return &Bot{
-		DataDir:  filepath.Join(os.Getenv("HOME"), "var", "maintainerbot"),
-		owner:    owner,
-		repoName: repo,
-		token:    token,
+	b := &Bot{
+		DataDir: filepath.Join(os.Getenv("HOME"), "var", "maintainerbot"),
+		token:   token,
 	}
+	b.WatchRepo(owner, repo)
+	return b
 }
 
-func (b *Bot) doTasks(ctx context.Context) error {
-	b.taskMu.Lock()
-	defer b.taskMu.Unlock()
-	for i := range b.tasks {
-		err := b.tasks[i].Do(ctx, b.repo)
-		if err != nil {
-			return err
-		}
+// WatchRepo adds owner/repo to the list of repositories tracked by the Bot. If
+// repo is empty, it is equivalent to WatchOrg(owner). It must be called before
+// Run.
+func (b *Bot) WatchRepo(owner, repo string) {
+	b.watched = append(b.watched, repoSpec{owner: owner, repo: repo})
+}
+
+// WatchOrg tracks every repository owned by org. The list of repositories is
+// fetched from GitHub when the Bot starts, so repositories created later are
+// not picked up until the Bot is restarted. It must be called before Run.
+func (b *Bot) WatchOrg(org string) {
+	b.watched = append(b.watched, repoSpec{owner: org})
+}
+
+// Run calls each registered task in turn with the updated contents of each
+// watched GitHub repository, until the context is canceled. If a task returns
+// a non-zero error or panics, it is logged to the console, and the task is
+// retried with exponential backoff; other tasks keep running on schedule.
+//
+// The corpus is synced every 15 seconds, or immediately when the handler
+// returned by WebhookHandler receives an event.
+//
+// When ctx is canceled, Run stops syncing and starting tasks, and waits up to
+// ShutdownTimeout for running tasks to finish before canceling the context
+// passed to them. It then closes every Task that implements io.Closer,
+// flushes the state store, and returns nil.
+//
+// Run returns an error if the corpus can't be loaded from disk and GitHub,
+// after retrying with backoff.
+func (b *Bot) Run(ctx context.Context) error {
+	err := b.loop(ctx)
+	if ctx.Err() != nil {
+		// Canceled; shut down cleanly.
+		err = nil
 	}
-	return nil
+	if serr := b.shutdown(); err == nil {
+		err = serr
+	}
+	return err
 }
 
-// Run calls each registered task in turn with the updated contents of the
-// GitHub repository, until the context is canceled. If a task returns a
-// non-zero error, it is logged to the console.
-func (b *Bot) Run(ctx context.Context) {
-	b.initCorpus(ctx)
+func (b *Bot) loop(ctx context.Context) error {
+	if err := b.initCorpusWithRetry(ctx); err != nil {
+		return err
+	}
 
 	ticker := time.NewTicker(15 * time.Second)
-	for ; true; <-ticker.C {
-		t0 := time.Now()
-		err := b.doTasks(ctx)
-		if err != nil {
-			log.Print(err)
+	defer ticker.Stop()
+	for {
+		// Tasks get their own context, so they can finish during the
+		// shutdown grace period after ctx is canceled.
+		done := make(chan struct{})
+		b.inflight.Add(1)
+		go func() {
+			defer b.inflight.Done()
+			defer close(done)
+			t0 := time.Now()
+			b.doTasks(b.taskContext())
+			botDur := time.Since(t0)
+			log.Printf("maintainerbot ran in %v", botDur.Round(time.Millisecond))
+			b.reportDryRun()
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		// Wait for the next tick, or for a webhook to tell us something
+		// changed, then sync so the tasks see the change.
+		select {
+		case <-ticker.C:
+		case <-b.wakeChan():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if b.tokenRotated() {
+			log.Print("GitHub token changed, reloading corpus")
+			if err := b.initCorpusWithRetry(ctx); err != nil {
+				return err
+			}
 		}
-		botDur := time.Since(t0)
-		log.Printf("maintainerbot ran in %v", botDur.Round(time.Millisecond))
 		for {
+			// Don't let the sync run into a rate limit GitHub told us about.
+			if err := b.budget().waitPause(ctx); err != nil {
+				return err
+			}
 			t0 := time.Now()
 			err := b.corpus.Sync(ctx)
 			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
 				if err == maintner.ErrSplit {
 					log.Print("Corpus out of sync. Re-fetching corpus.")
-					b.initCorpus(ctx)
+					if err := b.initCorpusWithRetry(ctx); err != nil {
+						return err
+					}
 				} else {
 					log.Printf("corpus.Sync: %v; sleeping 15s", err)
-					time.Sleep(15 * time.Second)
+					select {
+					case <-time.After(15 * time.Second):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
 					continue
 				}
 			}
 			log.Printf("got corpus update after %v", time.Since(t0).Round(time.Millisecond))
+			b.refreshBudget(ctx)
 			break
 		}
 	}
 }
 
+// taskContext returns the context passed to tasks. It is canceled when the
+// shutdown grace period runs out.
+func (b *Bot) taskContext() context.Context {
+	b.taskCtxOnce.Do(func() {
+		b.taskCtx, b.cancelTasks = context.WithCancel(context.Background())
+	})
+	return b.taskCtx
+}
+
+// stopTasks cancels the context returned by taskContext.
+func (b *Bot) stopTasks() {
+	b.taskContext()
+	b.cancelTasks()
+}
+
+// shutdown waits for running tasks and webhook handlers, then releases the
+// resources held by tasks and the Bot.
+func (b *Bot) shutdown() error {
+	grace := b.ShutdownTimeout
+	if grace == 0 {
+		grace = 30 * time.Second
+	}
+	b.inflightMu.Lock()
+	b.closing = true
+	b.inflightMu.Unlock()
+	idle := make(chan struct{})
+	go func() {
+		b.inflight.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+	case <-time.After(grace):
+		log.Printf("tasks still running after %v; canceling them", grace)
+		b.stopTasks()
+		select {
+		case <-idle:
+		case <-time.After(5 * time.Second):
+			log.Print("tasks did not stop after being canceled; shutting down anyway")
+		}
+	}
+	b.stopTasks()
+
+	var firstErr error
+	for _, e := range b.tasks {
+		c, ok := e.task.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := c.Close(); err != nil {
+			log.Printf("closing %T: %v", e.task, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	b.stateMu.Lock()
+	if b.state != nil {
+		if err := b.state.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	b.stateMu.Unlock()
+	b.reportDryRun()
+	return firstErr
+}
+
+// budget returns the Budget shared by the corpus and any other GitHub
+// requests the Bot makes on its own behalf.
+func (b *Bot) budget() *Budget {
+	b.budgetOnce.Do(func() {
+		if b.Budget != nil {
+			return
+		}
+		rateLimit := b.GitHubRateLimit
+		if rateLimit == 0 {
+			rateLimit = time.Hour / 5000
+		}
+		b.Budget = NewBudget(int(time.Hour / rateLimit))
+	})
+	return b.Budget
+}
+
+// refreshBudget asks GitHub how much of the rate limit is left, so the
+// Budget knows about the requests the corpus sync made. Checking the rate
+// limit doesn't count against it.
+func (b *Bot) refreshBudget(ctx context.Context) {
+	if time.Since(b.lastRefresh) < usageWindow {
+		return
+	}
+	b.lastRefresh = time.Now()
+	ghc := newGitHubClient(b.token, nil, b.clientOptions()...)
+	limits, _, err := ghc.RateLimits(ctx)
+	if err != nil {
+		log.Printf("checking GitHub rate limit: %v", err)
+		return
+	}
+	b.budget().setRate(limits.GetCore())
+}
+
+// orgRepos returns the names of every repository owned by org.
+func (b *Bot) orgRepos(ctx context.Context, org string) ([]string, error) {
+	ghc := newGitHubClient(b.token, nil, append(b.clientOptions(), UseBudget(b.budget(), Background))...)
+	opt := &github.RepositoryListByOrgOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	var names []string
+	for {
+		repos, resp, err := ghc.Repositories.ListByOrg(ctx, org, opt)
+		if err != nil {
+			return nil, err
+		}
+		for i := range repos {
+			names = append(names, repos[i].GetName())
+		}
+		if resp.NextPage == 0 {
+			return names, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+const (
+	// initAttempts is how many times the Bot tries to load the corpus before
+	// giving up. The wait between attempts starts at initBackoff and doubles
+	// each time.
+	initAttempts = 5
+	initBackoff  = 15 * time.Second
+)
+
+// initCorpusWithRetry calls initCorpus until it succeeds, the context is
+// canceled, or it has failed initAttempts times.
+func (b *Bot) initCorpusWithRetry(ctx context.Context) error {
+	backoff := initBackoff
+	for attempt := 1; ; attempt++ {
+		err := b.initCorpus(ctx)
+		if err == nil {
+			return nil
+		}
+		if attempt == initAttempts {
+			return fmt.Errorf("maintainerbot: loading corpus failed %d times, giving up: %v", attempt, err)
+		}
+		log.Printf("loading corpus: %v; retrying in %v", err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
 func (b *Bot) initCorpus(ctx context.Context) error {
+	token, err := b.currentToken()
+	if err != nil {
+		return err
+	}
 	corpus := new(maintner.Corpus)
-	logger := maintner.NewDiskMutationLogger(b.DataDir)
+	logger := &countingLogger{DiskMutationLogger: maintner.NewDiskMutationLogger(b.DataDir)}
+	if b.logger != nil {
+		// A fresh corpus counts as a change, so tasks that only run on change
+		// see the reloaded data.
+		logger.count = b.logger.generation() + 1
+	}
 	corpus.EnableLeaderMode(logger, b.DataDir)
-	corpus.TrackGitHub(b.owner, b.repoName, b.token)
-	rateLimit := b.GitHubRateLimit
-	if rateLimit == 0 {
-		rateLimit = time.Hour / 5000
+	var ids []maintner.GitHubRepoID
+	tracked := make(map[maintner.GitHubRepoID]bool)
+	track := func(id maintner.GitHubRepoID) {
+		if tracked[id] {
+			return
+		}
+		tracked[id] = true
+		ids = append(ids, id)
+		corpus.TrackGitHub(id.Owner, id.Repo, token)
 	}
-	limit := rate.Every(rateLimit)
-	corpus.SetGitHubLimiter(rate.NewLimiter(limit, 20))
+	for _, spec := range b.watched {
+		if spec.repo != "" {
+			track(maintner.GitHubRepoID{Owner: spec.owner, Repo: spec.repo})
+			continue
+		}
+		names, err := b.orgRepos(ctx, spec.owner)
+		if err != nil {
+			return fmt.Errorf("failed to list repos for %s: %v", spec.owner, err)
+		}
+		for _, name := range names {
+			track(maintner.GitHubRepoID{Owner: spec.owner, Repo: name})
+		}
+	}
+	corpus.SetGitHubLimiter(b.budget().Limiter(Background))
 
+	before, err := statMutationLogs(b.DataDir)
+	if err != nil {
+		return err
+	}
 	t0 := time.Now()
 	if err := corpus.Initialize(ctx, logger); err != nil {
-		// TODO: if Initialize only partially syncs the data, we need to delete
-		// whatever files it created, since Github returns events newest first
-		// and we use the issue updated dates to check whether we need to keep
-		// syncing.
-		log.Fatal(err)
+		// If Initialize only partially synced the data, we need to delete
+		// whatever it wrote, since Github returns events newest first and we
+		// use the issue updated dates to check whether we need to keep
+		// syncing. Otherwise the next attempt would skip the gap.
+		if rerr := rollbackMutationLogs(b.DataDir, before); rerr != nil {
+			log.Printf("failed to clean up partial mutation logs: %v", rerr)
+		}
+		return err
 	}
 	initDur := time.Since(t0)
 	runtime.GC()
@@ -139,16 +550,57 @@ func (b *Bot) initCorpus(ctx context.Context) error {
 	runtime.ReadMemStats(&ms)
 	log.Printf("Loaded data in %v. Memory: %v MB (%v bytes)", initDur.Round(time.Millisecond), ms.HeapAlloc>>20, ms.HeapAlloc)
 
-	repo := corpus.GitHub().Repo(b.owner, b.repoName)
-	if repo == nil {
-		log.Fatalf("Failed to find %s/%s repo in Corpus.", b.owner, b.repoName)
+	repos := make([]*maintner.GitHubRepo, 0, len(ids))
+	for _, id := range ids {
+		repo := corpus.GitHub().Repo(id.Owner, id.Repo)
+		if repo == nil {
+			return fmt.Errorf("failed to find %s repo in Corpus", id)
+		}
+		repos = append(repos, repo)
 	}
 
 	b.corpus = corpus
-	b.repo = repo
+	b.logger = logger
+	b.repos = repos
+	b.corpusToken = token
 	return nil
 }
 
+// currentToken returns the token the Bot should use for GitHub requests.
+func (b *Bot) currentToken() (string, error) {
+	if b.TokenSource == nil {
+		return b.token, nil
+	}
+	t, err := b.TokenSource.Token()
+	if err != nil {
+		return "", fmt.Errorf("getting GitHub token: %v", err)
+	}
+	return t.AccessToken, nil
+}
+
+// clientOptions returns the options for the clients the Bot uses on its own
+// behalf.
+func (b *Bot) clientOptions() []ClientOption {
+	if b.TokenSource == nil {
+		return nil
+	}
+	return []ClientOption{TokenSource(b.TokenSource)}
+}
+
+// tokenRotated reports whether TokenSource has replaced the token the corpus
+// was loaded with.
+func (b *Bot) tokenRotated() bool {
+	if b.TokenSource == nil {
+		return false
+	}
+	token, err := b.currentToken()
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return token != b.corpusToken
+}
+
 type limitTransport struct {
 	limiter *rate.Limiter
 	base    http.RoundTripper
@@ -167,16 +619,46 @@ func (t limitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 
 // NewGitHubClient creates a new GitHub client for the given token. rateLimit is
 // the duration between requests; a rate limit of 0 defaults to 5000 requests
-// per hour.
-func NewGitHubClient(token string, rateLimit time.Duration) *github.Client {
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-	tc := oauth2.NewClient(context.Background(), ts)
-	var transport http.RoundTripper = tc.Transport
+// per hour. opts can be used to change how the client talks to GitHub, for
+// example DryRun. If the client uses a Budget, rateLimit is ignored, and with
+// the TokenSource option, token is ignored.
+func NewGitHubClient(token string, rateLimit time.Duration, opts ...ClientOption) *github.Client {
 	if rateLimit == 0 {
 		rateLimit = time.Hour / 5000
 	}
 	limit := rate.Every(rateLimit)
-	transport = limitTransport{rate.NewLimiter(limit, 20), tc.Transport}
+	return newGitHubClient(token, rate.NewLimiter(limit, 20), opts...)
+}
+
+func newGitHubClient(token string, limiter *rate.Limiter, opts ...ClientOption) *github.Client {
+	cfg := new(clientConfig)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	var ts oauth2.TokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	if cfg.tokenSource != nil {
+		ts = cfg.tokenSource
+	}
+	tc := oauth2.NewClient(context.Background(), ts)
+	var transport http.RoundTripper = limitTransport{limiter, tc.Transport}
+	if cfg.budget != nil {
+		transport = budgetTransport{cfg.budget, cfg.priority, tc.Transport}
+	}
+	if cfg.cache != nil {
+		transport = cacheTransport{cfg.cache, transport}
+	}
+	if cfg.retry != nil {
+		// Every attempt waits for the rate limit.
+		transport = retryTransport{cfg.retry, transport}
+	}
+	if cfg.recorder != nil {
+		transport = dryRunTransport{cfg.recorder, transport}
+	}
 	httpClient := &http.Client{Transport: transport}
-	return github.NewClient(httpClient)
+	client := github.NewClient(httpClient)
+	if cfg.baseURL != nil {
+		client.BaseURL = cfg.baseURL
+		client.UploadURL = cfg.baseURL
+	}
+	return client
 }
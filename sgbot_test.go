@@ -0,0 +1,73 @@
+package maintainerbot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/build/maintner"
+)
+
+func TestWantRepo(t *testing.T) {
+	core := maintner.GitHubRepoID{Owner: "sourcegraph", Repo: "sourcegraph"}
+	other := maintner.GitHubRepoID{Owner: "sourcegraph", Repo: "maintainerbot"}
+	elsewhere := maintner.GitHubRepoID{Owner: "golang", Repo: "go"}
+	tests := []struct {
+		opts []TaskOption
+		id   maintner.GitHubRepoID
+		want bool
+	}{
+		{nil, core, true},
+		{[]TaskOption{OnlyRepos("sourcegraph/sourcegraph")}, core, true},
+		{[]TaskOption{OnlyRepos("sourcegraph/sourcegraph")}, other, false},
+		{[]TaskOption{OnlyRepos("sourcegraph")}, other, true},
+		{[]TaskOption{OnlyRepos("sourcegraph/*")}, elsewhere, false},
+		{[]TaskOption{SkipRepos("sourcegraph/maintainerbot")}, other, false},
+		{[]TaskOption{SkipRepos("sourcegraph/maintainerbot")}, core, true},
+		{[]TaskOption{OnlyRepos("sourcegraph"), SkipRepos("sourcegraph/sourcegraph")}, core, false},
+	}
+	for i, tt := range tests {
+		b := new(Bot)
+		b.RegisterTask(nil, tt.opts...)
+		if got := b.tasks[0].wantRepo(tt.id); got != tt.want {
+			t.Errorf("%d: wantRepo(%s): got %t, want %t", i, tt.id, got, tt.want)
+		}
+	}
+}
+
+type closingTask struct {
+	closed bool
+}
+
+func (c *closingTask) Do(ctx context.Context, repo *maintner.GitHubRepo) error { return nil }
+
+func (c *closingTask) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	b := &Bot{ShutdownTimeout: 10 * time.Millisecond}
+	task := new(closingTask)
+	b.RegisterTask(task)
+
+	// A task that only stops when its context is canceled.
+	stopped := make(chan struct{})
+	b.inflight.Add(1)
+	go func() {
+		defer b.inflight.Done()
+		<-b.taskContext().Done()
+		close(stopped)
+	}()
+	if err := b.shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("running task was not canceled after the grace period")
+	}
+	if !task.closed {
+		t.Error("task was not closed")
+	}
+}
@@ -0,0 +1,276 @@
+package maintainerbot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// StateStore is a small persistent key/value store for task state, such as
+// which pull requests a Task has already handled. Keys are grouped into
+// namespaces, usually one per Task, and values are stored as JSON.
+//
+// The store is kept in a single append-only file, which is compacted every
+// time it is opened. A StateStore is safe for concurrent use.
+type StateStore struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	data map[string]map[string]json.RawMessage
+	// If dryRun is set, writes are only kept in memory.
+	dryRun bool
+}
+
+// stateRecord is one line of the state file.
+type stateRecord struct {
+	Namespace string          `json:"ns"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Deleted   bool            `json:"deleted,omitempty"`
+}
+
+// OpenStateStore opens the store at path, creating it if it doesn't exist.
+// If the process crashed in the middle of a write, the partial record is
+// discarded.
+func OpenStateStore(path string) (*StateStore, error) {
+	s := &StateStore{
+		path: path,
+		data: make(map[string]map[string]json.RawMessage),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	return s, nil
+}
+
+// openStateSnapshot opens the store at path for a dry run: it starts out
+// with the contents of the file, if there is one, but writes are only kept in
+// memory, and the file is never changed.
+func openStateSnapshot(path string) (*StateStore, error) {
+	s := &StateStore{
+		path:   path,
+		data:   make(map[string]map[string]json.RawMessage),
+		dryRun: true,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *StateStore) load() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			// A partial write at the end of the file; drop it.
+			break
+		}
+		line := data[:i]
+		data = data[i+1:]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rec stateRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("state store %s: %v", s.path, err)
+		}
+		s.apply(rec)
+	}
+	return nil
+}
+
+func (s *StateStore) apply(rec stateRecord) {
+	ns := s.data[rec.Namespace]
+	if rec.Deleted {
+		delete(ns, rec.Key)
+		return
+	}
+	if ns == nil {
+		ns = make(map[string]json.RawMessage)
+		s.data[rec.Namespace] = ns
+	}
+	ns[rec.Key] = rec.Value
+}
+
+// compact rewrites the state file with one record per live key.
+func (s *StateStore) compact() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for ns, kv := range s.data {
+		for key, value := range kv {
+			if err := enc.Encode(stateRecord{Namespace: ns, Key: key, Value: value}); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *StateStore) write(rec stateRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dryRun {
+		s.apply(rec)
+		return nil
+	}
+	if s.f == nil {
+		return fmt.Errorf("state store %s is closed", s.path)
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	// Write each record straight to the file, so a crash loses at most the
+	// record being written. Syncing is left to Flush and Close.
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.apply(rec)
+	return nil
+}
+
+// Flush commits every write to stable storage.
+func (s *StateStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Sync()
+}
+
+// Close flushes the store and closes the underlying file.
+func (s *StateStore) Close() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// Bucket returns the StateBucket for namespace.
+func (s *StateStore) Bucket(namespace string) *StateBucket {
+	return &StateBucket{store: s, namespace: namespace}
+}
+
+// StateBucket is one namespace in a StateStore.
+type StateBucket struct {
+	store     *StateStore
+	namespace string
+}
+
+// Get decodes the value stored for key into v, and reports whether key was
+// found.
+func (b *StateBucket) Get(key string, v interface{}) (bool, error) {
+	b.store.mu.Lock()
+	value, ok := b.store.data[b.namespace][key]
+	b.store.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+// Put stores v, encoded as JSON, under key.
+func (b *StateBucket) Put(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.store.write(stateRecord{Namespace: b.namespace, Key: key, Value: value})
+}
+
+// Delete removes key. It is not an error to delete a key that doesn't exist.
+func (b *StateBucket) Delete(key string) error {
+	b.store.mu.Lock()
+	_, ok := b.store.data[b.namespace][key]
+	b.store.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return b.store.write(stateRecord{Namespace: b.namespace, Key: key, Deleted: true})
+}
+
+// Keys returns every key in the bucket, in sorted order.
+func (b *StateBucket) Keys() []string {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	keys := make([]string, 0, len(b.store.data[b.namespace]))
+	for key := range b.store.data[b.namespace] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// State returns the state bucket for namespace, stored in "state.jsonl" in
+// the Bot's DataDir. Each Task should use its own namespace. The store is
+// opened the first time State is called, so DataDir must be set before then.
+//
+// If the Bot has a Recorder, the store starts out with the saved state, but
+// what tasks write to it is never saved: the writes they record on GitHub
+// don't happen, so a later real run must not think they did. Set Recorder
+// before calling State.
+func (b *Bot) State(namespace string) (*StateBucket, error) {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	if b.state == nil {
+		open := OpenStateStore
+		if b.Recorder != nil {
+			open = openStateSnapshot
+		}
+		store, err := open(filepath.Join(b.DataDir, "state.jsonl"))
+		if err != nil {
+			return nil, err
+		}
+		b.state = store
+	}
+	return b.state.Bucket(namespace), nil
+}
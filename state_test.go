@@ -0,0 +1,119 @@
+package maintainerbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintainerbot-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.jsonl")
+
+	s, err := OpenStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cla := s.Bucket("cla")
+	if err := cla.Put("sourcegraph/sourcegraph#7", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cla.Put("sourcegraph/sourcegraph#8", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cla.Delete("sourcegraph/sourcegraph#8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Bucket("congrats").Put("kevinburke", 12); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of a write.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"ns":"cla","key":"sourcegraph/sourcegraph#9","val`)
+	f.Close()
+
+	s, err = OpenStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	cla = s.Bucket("cla")
+	if keys := cla.Keys(); !reflect.DeepEqual(keys, []string{"sourcegraph/sourcegraph#7"}) {
+		t.Errorf("wrong keys after reopening: %v", keys)
+	}
+	var signed bool
+	if ok, err := cla.Get("sourcegraph/sourcegraph#7", &signed); !ok || err != nil || !signed {
+		t.Errorf("Get: got %t, %t, %v", signed, ok, err)
+	}
+	var number int
+	if ok, err := s.Bucket("congrats").Get("kevinburke", &number); !ok || err != nil || number != 12 {
+		t.Errorf("Get: got %d, %t, %v", number, ok, err)
+	}
+	if ok, _ := s.Bucket("congrats").Get("sourcegraph/sourcegraph#7", &signed); ok {
+		t.Error("namespaces should not share keys")
+	}
+}
+
+func TestStateDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintainerbot-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.jsonl")
+	s, err := OpenStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Bucket("cla").Put("sourcegraph/sourcegraph#7", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New("sourcegraph", "sourcegraph", "")
+	b.DataDir = dir
+	b.Recorder = NewRecorder()
+	cla, err := b.State("cla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed bool
+	if ok, err := cla.Get("sourcegraph/sourcegraph#7", &signed); !ok || err != nil || !signed {
+		t.Errorf("dry run doesn't see the saved state: got %t, %t, %v", signed, ok, err)
+	}
+	if err := cla.Put("sourcegraph/sourcegraph#8", true); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := cla.Get("sourcegraph/sourcegraph#8", &signed); !ok {
+		t.Error("dry run doesn't see its own writes")
+	}
+	if err := b.state.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("dry run changed the state file:\n%s", after)
+	}
+}
@@ -0,0 +1,161 @@
+package maintainerbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/build/maintner"
+)
+
+const (
+	// minTaskBackoff is how long a Task waits before running again after
+	// its first failure. The wait doubles with every consecutive failure, up
+	// to maxTaskBackoff.
+	minTaskBackoff = 30 * time.Second
+	maxTaskBackoff = time.Hour
+)
+
+// Timeout limits each run of a Task to d. The deadline is set on the context
+// passed to Do, so the Task must pass the context to any GitHub calls it
+// makes. If the Bot's context already has an earlier deadline, that deadline
+// is used instead.
+func Timeout(d time.Duration) TaskOption {
+	return func(e *taskEntry) {
+		e.timeout = d
+	}
+}
+
+// repoErrors collects the errors from running a Task against several
+// repositories.
+type repoErrors []error
+
+func (r repoErrors) Error() string {
+	msgs := make([]string, len(r))
+	for i := range r {
+		msgs[i] = r[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// RunAlone marks a Task as unsafe to run alongside other tasks. When the Bot
+// runs tasks concurrently, it waits for every running Task to finish before
+// starting this one, and starts no other Task until it is done.
+func RunAlone() TaskOption {
+	return func(e *taskEntry) {
+		e.alone = true
+	}
+}
+
+// doTasks runs every due task once. The corpus is read locked for the
+// duration, so a concurrent Sync can't mutate a repo while a Task reads it.
+func (b *Bot) doTasks(ctx context.Context) {
+	b.taskMu.Lock()
+	defer b.taskMu.Unlock()
+	if b.corpus != nil {
+		b.corpus.RLock()
+		defer b.corpus.RUnlock()
+	}
+	b.collectEvents()
+	now := time.Now()
+	generation := b.logger.generation()
+	var due []*taskEntry
+	b.statusMu.Lock()
+	for _, e := range b.tasks {
+		if e.due(now, generation) {
+			e.ran(now, generation)
+			due = append(due, e)
+		}
+	}
+	b.statusMu.Unlock()
+
+	if b.Concurrency <= 1 {
+		for _, e := range due {
+			b.runTask(ctx, e)
+		}
+		return
+	}
+	sem := make(chan struct{}, b.Concurrency)
+	var wg sync.WaitGroup
+	for _, e := range due {
+		if e.alone {
+			wg.Wait()
+			b.runTask(ctx, e)
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(e *taskEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			b.runTask(ctx, e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// runTask runs e against every repository it wants and records the outcome.
+// A failure in one repository doesn't prevent e from running against the
+// others.
+func (b *Bot) runTask(ctx context.Context, e *taskEntry) {
+	var errs repoErrors
+	for _, repo := range b.repos {
+		if !e.wantRepo(repo.ID()) {
+			continue
+		}
+		var err error
+		if _, ok := e.task.(EventTask); ok {
+			err = b.doEvents(ctx, e, repo)
+		} else {
+			err = e.do(ctx, repo)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%T on %s: %v", e.task, repo.ID(), err))
+		}
+	}
+	b.statusMu.Lock()
+	defer b.statusMu.Unlock()
+	e.runs++
+	if len(errs) == 0 {
+		e.consecutive = 0
+		return
+	}
+	e.failures++
+	e.consecutive++
+	e.lastErr = errs
+	backoff := minTaskBackoff << uint(e.consecutive-1)
+	if backoff > maxTaskBackoff || backoff <= 0 {
+		backoff = maxTaskBackoff
+	}
+	if next := time.Now().Add(backoff); next.After(e.nextRun) {
+		e.nextRun = next
+	}
+	log.Printf("%v (failure %d in a row, retrying in %v)", errs, e.consecutive, backoff)
+}
+
+// do calls the Task's Do method once.
+func (e *taskEntry) do(ctx context.Context, repo *maintner.GitHubRepo) error {
+	return e.call(ctx, func(ctx context.Context) error {
+		return e.task.Do(ctx, repo)
+	})
+}
+
+// call calls fn with the Task's timeout applied to ctx, converting a panic
+// into an error.
+func (e *taskEntry) call(ctx context.Context, fn func(context.Context) error) (err error) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return fn(ctx)
+}
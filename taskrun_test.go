@@ -0,0 +1,121 @@
+package maintainerbot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/build/maintner"
+)
+
+type funcTask func(ctx context.Context, repo *maintner.GitHubRepo) error
+
+func (f funcTask) Do(ctx context.Context, repo *maintner.GitHubRepo) error {
+	return f(ctx, repo)
+}
+
+func TestTaskFailuresAreIsolated(t *testing.T) {
+	b := new(Bot)
+	b.repos = []*maintner.GitHubRepo{new(maintner.GitHubRepo)}
+	healthyRuns := 0
+	b.RegisterTask(funcTask(func(ctx context.Context, repo *maintner.GitHubRepo) error {
+		panic("boom")
+	}))
+	b.RegisterTask(funcTask(func(ctx context.Context, repo *maintner.GitHubRepo) error {
+		return errors.New("not today")
+	}))
+	b.RegisterTask(funcTask(func(ctx context.Context, repo *maintner.GitHubRepo) error {
+		healthyRuns++
+		return nil
+	}))
+	b.doTasks(context.Background())
+	b.doTasks(context.Background())
+	if healthyRuns != 2 {
+		t.Errorf("healthy task: got %d runs, want 2", healthyRuns)
+	}
+	statuses := b.TaskStatus()
+	for _, status := range statuses[:2] {
+		if status.Runs != 1 || status.Failures != 1 || status.ConsecutiveFailures != 1 {
+			t.Errorf("failing task: got runs=%d failures=%d consecutive=%d, want 1 of each", status.Runs, status.Failures, status.ConsecutiveFailures)
+		}
+		if status.LastError == nil {
+			t.Error("failing task: LastError should be set")
+		}
+		if until := time.Until(status.NextRun); until <= 0 || until > minTaskBackoff {
+			t.Errorf("failing task: next run in %v, want backoff of up to %v", until, minTaskBackoff)
+		}
+	}
+	if statuses[2].Failures != 0 || statuses[2].Runs != 2 {
+		t.Errorf("healthy task: got runs=%d failures=%d", statuses[2].Runs, statuses[2].Failures)
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	e := &taskEntry{timeout: time.Millisecond, task: funcTask(func(ctx context.Context, repo *maintner.GitHubRepo) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})}
+	if err := e.do(context.Background(), nil); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConcurrentTasks(t *testing.T) {
+	b := &Bot{Concurrency: 2}
+	b.repos = []*maintner.GitHubRepo{new(maintner.GitHubRepo)}
+	var mu sync.Mutex
+	running, maxRunning, started := 0, 0, 0
+	aloneOverlapped := false
+	// The first two tasks wait for each other, so both are certainly
+	// running at the same time. If they never overlap, they give up after
+	// a while and the test fails instead of hanging.
+	bothRunning := make(chan struct{})
+	var closeOnce sync.Once
+	track := func(alone bool) Task {
+		return funcTask(func(ctx context.Context, repo *maintner.GitHubRepo) error {
+			mu.Lock()
+			running++
+			started++
+			first := started <= 2
+			if running > maxRunning {
+				maxRunning = running
+			}
+			if running == 2 {
+				closeOnce.Do(func() { close(bothRunning) })
+			}
+			if alone && running > 1 {
+				aloneOverlapped = true
+			}
+			mu.Unlock()
+			if first {
+				select {
+				case <-bothRunning:
+				case <-time.After(5 * time.Second):
+				}
+			}
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+	}
+	for i := 0; i < 4; i++ {
+		b.RegisterTask(track(false))
+	}
+	b.RegisterTask(track(true), RunAlone())
+	b.RegisterTask(track(false))
+	b.doTasks(context.Background())
+	if maxRunning != 2 {
+		t.Errorf("got %d tasks running at once, want 2", maxRunning)
+	}
+	if aloneOverlapped {
+		t.Error("RunAlone task overlapped with another task")
+	}
+	for _, status := range b.TaskStatus() {
+		if status.Runs != 1 {
+			t.Errorf("%s: got %d runs, want 1", status.Name, status.Runs)
+		}
+	}
+}
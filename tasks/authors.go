@@ -0,0 +1,77 @@
+package tasks
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// coAuthorRE matches the "Co-authored-by: Name <email>" trailers GitHub uses
+// to credit more than one author for a commit.
+var coAuthorRE = regexp.MustCompile(`(?mi)^co-authored-by:.*<([^>\s]+)>\s*$`)
+
+// noreplySuffix is the domain of the private email addresses GitHub gives
+// its users.
+const noreplySuffix = "@users.noreply.github.com"
+
+// emailIdentity returns who email belongs to: the GitHub login, for one of
+// GitHub's private addresses, or else the address itself.
+func emailIdentity(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !strings.HasSuffix(email, noreplySuffix) {
+		return email
+	}
+	login := strings.TrimSuffix(email, noreplySuffix)
+	// Newer addresses are prefixed with the user's ID, "123+login".
+	if i := strings.Index(login, "+"); i >= 0 {
+		login = login[i+1:]
+	}
+	return login
+}
+
+// displayName formats someone returned by commitAuthors for a status or a
+// check run: "@login" for a GitHub user, or their email address.
+func displayName(who string) string {
+	if strings.Contains(who, "@") {
+		return who
+	}
+	return "@" + who
+}
+
+// commitAuthors returns everyone who authored or committed one of the
+// commits in a pull request, or is credited as a co-author in a commit
+// message. People are named by their GitHub login, or by their email address
+// if it isn't linked to an account.
+func (c *CLAChecker) commitAuthors(ctx context.Context, owner, repo string, number int) ([]string, error) {
+	var people []string
+	add := func(user *github.User, author *github.CommitAuthor) {
+		switch {
+		case user.GetLogin() == "web-flow":
+			// GitHub commits changes made in the web interface itself.
+		case user.GetLogin() != "":
+			people = append(people, user.GetLogin())
+		case author.GetEmail() != "":
+			people = append(people, emailIdentity(author.GetEmail()))
+		}
+	}
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		commits, resp, err := c.ghc.PullRequests.ListCommits(ctx, owner, repo, number, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, commit := range commits {
+			add(commit.Author, commit.GetCommit().Author)
+			add(commit.Committer, commit.GetCommit().Committer)
+			for _, m := range coAuthorRE.FindAllStringSubmatch(commit.GetCommit().GetMessage(), -1) {
+				people = append(people, emailIdentity(m[1]))
+			}
+		}
+		if resp.NextPage == 0 {
+			return people, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
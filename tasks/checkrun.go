@@ -0,0 +1,219 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// checkRunsPreview is the media type of the preview Checks API.
+const checkRunsPreview = "application/vnd.github.antiope-preview+json"
+
+// recheckAction identifies the "Re-run" button on the CLAChecker's check
+// runs.
+const recheckAction = "recheck"
+
+// maxAnnotations is the most annotations GitHub accepts in one request.
+const maxAnnotations = 50
+
+// checkRunRequest is the body of a request to create a check run. go-github's
+// CreateCheckRunOptions predates actions, and uses old names for the
+// annotation fields.
+type checkRunRequest struct {
+	Name        string           `json:"name"`
+	HeadSHA     string           `json:"head_sha"`
+	DetailsURL  string           `json:"details_url,omitempty"`
+	ExternalID  string           `json:"external_id,omitempty"`
+	Status      string           `json:"status"`
+	Conclusion  string           `json:"conclusion"`
+	CompletedAt time.Time        `json:"completed_at"`
+	Output      checkRunOutput   `json:"output"`
+	Actions     []checkRunAction `json:"actions,omitempty"`
+}
+
+type checkRunOutput struct {
+	Title       string               `json:"title"`
+	Summary     string               `json:"summary"`
+	Annotations []checkRunAnnotation `json:"annotations,omitempty"`
+}
+
+type checkRunAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title,omitempty"`
+	Message         string `json:"message"`
+}
+
+type checkRunAction struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Identifier  string `json:"identifier"`
+}
+
+// createCheckRun publishes state, as accepted by postStatus, as a completed
+// check run on the head of pr.
+func (c *CLAChecker) createCheckRun(ctx context.Context, owner, repo string, pr *github.PullRequest, files []*github.CommitFile, state string, res claResult) (*github.CheckRun, error) {
+	login := pr.GetUser().GetLogin()
+	body := &checkRunRequest{
+		Name:        c.statusContext(),
+		HeadSHA:     pr.GetHead().GetSHA(),
+		ExternalID:  strconv.Itoa(pr.GetNumber()),
+		Status:      "completed",
+		Conclusion:  "success",
+		CompletedAt: time.Now(),
+		Actions: []checkRunAction{{
+			Label:       "Re-run",
+			Description: "Check the CLA again",
+			Identifier:  recheckAction,
+		}},
+	}
+	var summary bytes.Buffer
+	switch state {
+	case "failure":
+		body.Conclusion = "failure"
+		body.DetailsURL = c.claURL
+		body.Output.Title = "CLA not signed"
+		names := make([]string, len(res.missing))
+		for i := range res.missing {
+			names[i] = displayName(res.missing[i])
+		}
+		if len(names) == 1 {
+			fmt.Fprintf(&summary, "%s has not signed the Contributor License Agreement (CLA), so this pull request can't be merged yet.\n\n", names[0])
+		} else {
+			summary.WriteString("These people worked on this pull request, but have not signed the Contributor License Agreement (CLA), so it can't be merged yet:\n\n")
+			for _, name := range names {
+				fmt.Fprintf(&summary, "- %s\n", name)
+			}
+			summary.WriteString("\n")
+		}
+		if c.claURL != "" {
+			fmt.Fprintf(&summary, "Please [sign the CLA](%s). ", c.claURL)
+		}
+		summary.WriteString("Once it is signed, press **Re-run** to check again.\n")
+		for _, f := range files {
+			if len(body.Output.Annotations) == maxAnnotations {
+				break
+			}
+			if f.GetStatus() == "removed" {
+				continue
+			}
+			body.Output.Annotations = append(body.Output.Annotations, checkRunAnnotation{
+				Path:            f.GetFilename(),
+				StartLine:       1,
+				EndLine:         1,
+				AnnotationLevel: "failure",
+				Title:           "CLA not signed",
+				Message:         fmt.Sprintf("%s must sign the CLA before this change can be merged.", strings.Join(names, ", ")),
+			})
+		}
+	case "unnecessary":
+		body.Output.Title = "CLA not required"
+		reason := "These changes are small enough not to need one."
+		if c.SkipReason != nil {
+			if r := c.SkipReason(pr, files); r != "" {
+				reason = r
+			}
+		}
+		fmt.Fprintf(&summary, "This pull request doesn't require a signed CLA. %s\n", reason)
+	case "success":
+		body.Output.Title = "CLA signed"
+		cla := "Contributor License Agreement"
+		if c.claURL != "" {
+			cla = "[" + cla + "](" + c.claURL + ")"
+		}
+		if len(res.covered) == 1 && res.covered[0].individual() {
+			fmt.Fprintf(&summary, "@%s has signed the %s", login, cla)
+			if d := res.covered[0].entry.details(); d != "" {
+				fmt.Fprintf(&summary, " (%s)", d)
+			}
+			summary.WriteString(".\n")
+			break
+		}
+		fmt.Fprintf(&summary, "Everyone who worked on this pull request is covered by a %s:\n\n", cla)
+		for _, cov := range res.covered {
+			fmt.Fprintf(&summary, "- %s: %s\n", displayName(cov.who), cov.agreement())
+		}
+	default:
+		panic("unknown state " + state)
+	}
+	body.Output.Summary = summary.String()
+
+	req, err := c.ghc.NewRequest("POST", fmt.Sprintf("repos/%v/%v/check-runs", owner, repo), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", checkRunsPreview)
+	run := new(github.CheckRun)
+	if _, err := c.ghc.Do(ctx, req, run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// lastCheckRun returns the conclusion of the newest completed check run the
+// CLAChecker created on sha, or "" if there is none.
+func (c *CLAChecker) lastCheckRun(ctx context.Context, owner, repo, sha string) (string, error) {
+	runs, _, err := c.ghc.Checks.ListCheckRunsForRef(ctx, owner, repo, sha, &github.ListCheckRunsOptions{
+		CheckName: github.String(c.statusContext()),
+	})
+	if err != nil {
+		return "", err
+	}
+	var last *github.CheckRun
+	for _, run := range runs.CheckRuns {
+		if last == nil || run.GetID() > last.GetID() {
+			last = run
+		}
+	}
+	if last == nil || last.GetStatus() != "completed" {
+		return "", nil
+	}
+	return last.GetConclusion(), nil
+}
+
+// HandleWebhook checks a pull request again when someone asks GitHub to
+// re-run its CLA check run, or presses the check run's "Re-run" button. The
+// list of contributors is reloaded first, so a CLA signed a moment ago
+// counts.
+func (c *CLAChecker) HandleWebhook(ctx context.Context, event interface{}) error {
+	e, ok := event.(*github.CheckRunEvent)
+	if !ok || !c.UseCheckRuns || e.GetCheckRun().GetName() != c.statusContext() {
+		return nil
+	}
+	switch e.GetAction() {
+	case "rerequested", "requested_action":
+		// go-github doesn't decode which action was requested, but
+		// recheckAction is the only one we offer.
+	default:
+		return nil
+	}
+	// GitHub leaves out pull requests from forks, so fall back to the
+	// number saved in the check run.
+	numbers := make(map[int]bool)
+	for _, pr := range e.GetCheckRun().PullRequests {
+		numbers[pr.GetNumber()] = true
+	}
+	if n, err := strconv.Atoi(e.GetCheckRun().GetExternalID()); err == nil {
+		numbers[n] = true
+	}
+	if len(numbers) == 0 {
+		return nil
+	}
+	if err := c.Refresh(ctx); err != nil {
+		return err
+	}
+	owner, repoName := e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName()
+	for number := range numbers {
+		if _, err := c.check(ctx, owner, repoName, number, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
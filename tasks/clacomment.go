@@ -0,0 +1,189 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-github/github"
+)
+
+// CLACommentData is the data rendered into the templates passed to
+// CLAChecker.SetComments. More fields may be added.
+type CLACommentData struct {
+	// Username is the login of the pull request's author.
+	Username string
+	// Missing is who still has to sign the CLA, as "@login" or an email
+	// address. It is empty once the CLA is signed.
+	Missing []string
+	// CLAURL is where to sign the CLA.
+	CLAURL string
+	// Skipped is set if the pull request no longer needs a CLA, because
+	// CanSkipCLA returned true for it.
+	Skipped bool
+}
+
+// SetComments makes the CLAChecker comment on a pull request when its CLA
+// check fails, in addition to posting a status. missing is the comment to
+// post, and signed is what the comment is edited to say once the check
+// passes. Both are templates rendered with a CLACommentData, and may use
+// markdown or HTML, as long as GitHub will accept it. For example:
+//
+//     cla.SetComments(
+//         "Thanks for the pull request, @{{ .Username }}! Please [sign our CLA]({{ .CLAURL }}).",
+//         "Thanks for signing the CLA, @{{ .Username }}!",
+//     )
+//
+// The CLAChecker only ever posts one comment on a pull request. It finds the
+// comment again by a hidden marker, and edits it when the people missing
+// from the CLA change, or when the check passes.
+func (c *CLAChecker) SetComments(missing, signed string) {
+	c.missingComment = template.Must(template.New("cla-missing").Parse(missing))
+	c.signedComment = template.Must(template.New("cla-signed").Parse(signed))
+}
+
+// commentMarker identifies the CLAChecker's comment on a pull request.
+func (c *CLAChecker) commentMarker() string {
+	return "<!-- " + c.statusContext() + " comment -->"
+}
+
+// comment posts or updates the CLAChecker's comment on pr for state, as
+// accepted by postStatus. A comment is only posted when the check fails; when
+// it passes, an earlier comment is edited, if there is one.
+func (c *CLAChecker) comment(ctx context.Context, owner, repo string, pr *github.PullRequest, state string, res claResult) error {
+	if c.missingComment == nil {
+		return nil
+	}
+	key := prKey(owner, repo, int32(pr.GetNumber()))
+	existing, err := c.existingComment(ctx, owner, repo, pr.GetNumber())
+	if err != nil {
+		return err
+	}
+	tpl := c.missingComment
+	if state != "failure" {
+		if existing == nil {
+			return nil
+		}
+		tpl = c.signedComment
+	}
+	data := &CLACommentData{
+		Username: pr.GetUser().GetLogin(),
+		CLAURL:   c.claURL,
+		Skipped:  state == "unnecessary",
+	}
+	if state == "failure" {
+		for _, who := range res.missing {
+			data.Missing = append(data.Missing, displayName(who))
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := tpl.Execute(buf, data); err != nil {
+		return err
+	}
+	buf.WriteString("\n\n" + c.commentMarker())
+	body := buf.String()
+	if existing == nil {
+		posted, _, err := c.ghc.Issues.CreateComment(ctx, owner, repo, pr.GetNumber(), &github.IssueComment{
+			Body: github.String(body),
+		})
+		if err != nil {
+			return err
+		}
+		c.rememberComment(key, posted)
+		return nil
+	}
+	if existing.GetBody() == body {
+		return nil
+	}
+	edited, _, err := c.ghc.Issues.EditComment(ctx, owner, repo, existing.GetID(), &github.IssueComment{
+		Body: github.String(body),
+	})
+	if err != nil {
+		// Someone may have deleted the comment; look for it again next
+		// time.
+		c.mu.Lock()
+		delete(c.comments, key)
+		c.mu.Unlock()
+		return err
+	}
+	c.rememberComment(key, edited)
+	return nil
+}
+
+// existingComment returns the CLAChecker's comment on a pull request, or nil
+// if there is none. Only the first call for a pull request lists its
+// comments; the CLAChecker remembers the comments it finds and posts.
+func (c *CLAChecker) existingComment(ctx context.Context, owner, repo string, number int) (*github.IssueComment, error) {
+	key := prKey(owner, repo, int32(number))
+	c.mu.Lock()
+	comment, ok := c.comments[key]
+	c.mu.Unlock()
+	if ok {
+		return comment, nil
+	}
+	comment, err := c.findComment(ctx, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	c.rememberComment(key, comment)
+	return comment, nil
+}
+
+func (c *CLAChecker) rememberComment(key string, comment *github.IssueComment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.comments == nil {
+		c.comments = make(map[string]*github.IssueComment)
+	}
+	c.comments[key] = comment
+}
+
+// findComment returns the comment the CLAChecker posted on a pull request,
+// or nil if there is none. Anyone can copy the marker into a comment, so only
+// comments written by the client's own user count.
+func (c *CLAChecker) findComment(ctx context.Context, owner, repo string, number int) (*github.IssueComment, error) {
+	login, err := c.botLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	marker := c.commentMarker()
+	opt := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := c.ghc.Issues.ListComments(ctx, owner, repo, number, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			if strings.EqualFold(comment.GetUser().GetLogin(), login) && strings.Contains(comment.GetBody(), marker) {
+				return comment, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// botLogin returns BotLogin, or else the login of the user the client is
+// authenticated as, looking it up the first time.
+func (c *CLAChecker) botLogin(ctx context.Context) (string, error) {
+	if c.BotLogin != "" {
+		return c.BotLogin, nil
+	}
+	c.mu.Lock()
+	login := c.login
+	c.mu.Unlock()
+	if login != "" {
+		return login, nil
+	}
+	user, _, err := c.ghc.Users.Get(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.login = user.GetLogin()
+	c.mu.Unlock()
+	return user.GetLogin(), nil
+}
@@ -0,0 +1,170 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Contributor is an entry in the list of people who signed the CLA.
+type Contributor struct {
+	// Login is the signer's GitHub username, or a corporate agreement, like
+	// "org:sourcegraph"; see ContributorFetcher. It may be empty if Email is
+	// set.
+	Login string `json:"login,omitempty"`
+	// Email is the address the signer commits with, if known. Commits
+	// authored with it count as signed even if they aren't linked to Login.
+	Email string `json:"email,omitempty"`
+	// Signed is when the agreement was signed, if known.
+	Signed time.Time `json:"signed,omitempty"`
+	// Version is the version of the agreement that was signed, if known.
+	Version string `json:"version,omitempty"`
+	// Company is the corporate entity that signed the agreement on the
+	// signer's behalf, if any.
+	Company string `json:"company,omitempty"`
+}
+
+// UnmarshalJSON accepts a bare string as well as an object, so a JSON list of
+// usernames decodes to a list of Contributors.
+func (c *Contributor) UnmarshalJSON(data []byte) error {
+	var login string
+	if err := json.Unmarshal(data, &login); err == nil {
+		*c = Contributor{Login: login}
+		return nil
+	}
+	type contributor Contributor
+	return json.Unmarshal(data, (*contributor)(c))
+}
+
+func (c Contributor) String() string {
+	if c.Login != "" {
+		return c.Login
+	}
+	return c.Email
+}
+
+// githubURLPrefixes are the ways people paste a link to their GitHub
+// profile instead of their username.
+var githubURLPrefixes = []string{"https://", "http://", "www.", "github.com/"}
+
+// NormalizeLogin returns the GitHub username in s, the way a signer might
+// type it: "@KevinBurke", " kevinburke " and "https://github.com/kevinburke/"
+// all return "kevinburke". GitHub usernames aren't case sensitive, so the
+// result is lower case. Corporate agreements, like "org:Sourcegraph", are
+// returned with the name lower cased.
+func NormalizeLogin(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if sg := parseSigner(s); sg.kind != "user" {
+		return sg.kind + ":" + sg.name
+	}
+	for _, prefix := range githubURLPrefixes {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimPrefix(s, "@")
+}
+
+// loginRE matches a normalized GitHub username. Enterprise accounts may
+// contain underscores, and some old accounts break the rules GitHub
+// enforces today, so it isn't strict.
+var loginRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Normalize returns c with its Login normalized by NormalizeLogin, and its
+// Email trimmed and lower cased.
+func (c Contributor) Normalize() Contributor {
+	c.Login = NormalizeLogin(c.Login)
+	c.Email = strings.ToLower(strings.TrimSpace(c.Email))
+	c.Version = strings.TrimSpace(c.Version)
+	c.Company = strings.TrimSpace(c.Company)
+	return c
+}
+
+// Validate returns an error if c, once normalized, can't match anyone.
+func (c Contributor) Validate() error {
+	c = c.Normalize()
+	if c.Login == "" && c.Email == "" {
+		return errors.New("no GitHub username or email address")
+	}
+	if s := parseSigner(c.Login); s.kind != "user" {
+		if s.name == "" {
+			return fmt.Errorf("%s agreement without a name", s.kind)
+		}
+		if s.kind == "team" && !strings.Contains(s.name, "/") {
+			return fmt.Errorf("invalid team %q, want org/team-slug", s.name)
+		}
+	} else if c.Login != "" && !loginRE.MatchString(c.Login) {
+		return fmt.Errorf("invalid GitHub username %q", c.Login)
+	}
+	if c.Email != "" {
+		at := strings.Index(c.Email, "@")
+		if at <= 0 || at == len(c.Email)-1 || strings.Count(c.Email, "@") != 1 || strings.ContainsAny(c.Email, " \t,;<>") {
+			return fmt.Errorf("invalid email address %q", c.Email)
+		}
+	}
+	return nil
+}
+
+// details describes the version and date of the agreement c signed, like
+// "v2, signed 2018-03-01", or returns "" if neither is known.
+func (c Contributor) details() string {
+	var parts []string
+	if c.Version != "" {
+		v := c.Version
+		if v[0] >= '0' && v[0] <= '9' {
+			v = "v" + v
+		}
+		parts = append(parts, v)
+	}
+	if !c.Signed.IsZero() {
+		parts = append(parts, "signed "+c.Signed.Format("2006-01-02"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// logins returns the names of contributors, for logging.
+func logins(contributors []Contributor) string {
+	names := make([]string, len(contributors))
+	for i := range contributors {
+		names[i] = contributors[i].String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// validContributors normalizes contributors, and drops and logs the ones that
+// are malformed.
+func validContributors(contributors []Contributor) []Contributor {
+	valid := make([]Contributor, 0, len(contributors))
+	for _, c := range contributors {
+		if err := c.Validate(); err != nil {
+			log.Printf("skipping CLA signer %q: %v", c.String(), err)
+			continue
+		}
+		valid = append(valid, c.Normalize())
+	}
+	return valid
+}
+
+// signedLayouts are the date formats accepted in a spreadsheet's signed
+// column. The third is the format of a Google Forms timestamp.
+var signedLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"1/2/2006 15:04:05",
+	"1/2/2006",
+	"2006-01-02 15:04:05",
+}
+
+func parseSignedDate(s string) (time.Time, error) {
+	for _, layout := range signedLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
@@ -0,0 +1,683 @@
+package tasks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// FileFetcher reads contributors from a local file. The format depends on the
+// file's extension:
+//
+//     .csv            the column named ColumnName, as for SpreadsheetFetcher
+//     .yaml or .yml   a list of contributors, in the YAML subset below
+//     anything else   one username per line
+//
+// Blank lines, and in the last two formats "#" comments, are ignored. A "#"
+// starts a comment at the start of a line or after a space, outside quotes.
+// The file is read again when its size or modification time changes.
+//
+// FileFetcher doesn't use a full YAML parser. A YAML file must hold a block
+// sequence, one "- item" per line, either at the top level or as the value
+// of the only key in the document, optionally after a "---" line. Each item
+// is a username, plain or quoted, or a mapping with some of the keys login,
+// email, signed, version and company, one per line:
+//
+//     contributors:
+//       - kevinburke
+//       - "org:sourcegraph"
+//       - login: pat-doe
+//         signed: 2018-03-02
+//         company: "Acme #1"
+//
+// Anything else, such as a flow sequence like "[a, b]", a second key, nested
+// mappings or multi-line strings, is an error.
+type FileFetcher struct {
+	path string
+
+	// Column name to match against in a CSV file, defaults to "GitHub
+	// Username", matches are case insensitive.
+	ColumnName string
+
+	mu           sync.Mutex
+	modTime      time.Time
+	size         int64
+	contributors []Contributor
+}
+
+// NewFileFetcher creates a FileFetcher that reads contributors from path.
+func NewFileFetcher(path string) *FileFetcher {
+	return &FileFetcher{
+		path:       path,
+		ColumnName: "GitHub Username",
+	}
+}
+
+// LoadContributors satisfies the ContributorFetcher interface.
+func (f *FileFetcher) LoadContributors(ctx context.Context) ([]Contributor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.contributors != nil && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return f.contributors, nil
+	}
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var contributors []Contributor
+	var usernames []string
+	switch strings.ToLower(filepath.Ext(f.path)) {
+	case ".csv":
+		columnName := f.ColumnName
+		if columnName == "" {
+			columnName = "GitHub Username"
+		}
+		contributors, err = getContributors(data, columnName)
+	case ".yaml", ".yml":
+		contributors, err = parseYAMLList(data)
+	default:
+		usernames = parseLines(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.path, err)
+	}
+	for _, username := range usernames {
+		contributors = append(contributors, Contributor{Login: username})
+	}
+	if contributors == nil {
+		contributors = []Contributor{}
+	}
+	f.contributors, f.modTime, f.size = contributors, fi.ModTime(), fi.Size()
+	return contributors, nil
+}
+
+// stripComment removes a "#" comment, and surrounding space, from line. Like
+// in YAML, a "#" only starts a comment at the start of the line or after
+// whitespace, and not inside a quoted string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		space := i == 0 || line[i-1] == ' ' || line[i-1] == '\t'
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (space || line[i-1] == '-'):
+			quote = c
+		case c == '#' && space:
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+func parseLines(data []byte) []string {
+	var lines []string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if line := stripComment(s.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseYAMLList parses the subset of YAML described in the FileFetcher
+// documentation.
+func parseYAMLList(data []byte) ([]Contributor, error) {
+	var contributors []Contributor
+	sawKey := false
+	// fields holds the keys of the list item being read, if it is a
+	// mapping, which started on itemLine with its "-" at itemIndent.
+	var fields map[string]string
+	var itemLine, itemIndent int
+	endItem := func() error {
+		if fields == nil {
+			return nil
+		}
+		c, err := contributorFromFields(fields)
+		fields = nil
+		if err != nil {
+			return fmt.Errorf("line %d: %v", itemLine, err)
+		}
+		contributors = append(contributors, c)
+		return nil
+	}
+	for n, raw := range strings.Split(string(data), "\n") {
+		line := stripComment(raw)
+		if line == "" || line == "---" {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		if fields != nil && indent > itemIndent && !strings.HasPrefix(line, "-") {
+			key, value, ok := yamlKeyValue(line)
+			if !ok {
+				return nil, fmt.Errorf("line %d: got %q, want a \"key: value\" pair", n+1, line)
+			}
+			if _, dup := fields[key]; dup {
+				return nil, fmt.Errorf("line %d: %s is repeated", n+1, key)
+			}
+			fields[key] = value
+			continue
+		}
+		if err := endItem(); err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasPrefix(line, "- ") || line == "-":
+			item := strings.TrimSpace(line[1:])
+			if key, value, ok := yamlKeyValue(item); ok {
+				fields = map[string]string{key: value}
+				itemLine, itemIndent = n+1, indent
+			} else if item = unquoteYAML(item); item != "" {
+				contributors = append(contributors, Contributor{Login: item})
+			}
+		case strings.HasSuffix(line, ":") && !sawKey && len(contributors) == 0:
+			sawKey = true
+		default:
+			return nil, fmt.Errorf("line %d: got %q, want a \"- username\" list item; only a block list of contributors, optionally under one key, is supported", n+1, line)
+		}
+	}
+	if err := endItem(); err != nil {
+		return nil, err
+	}
+	return contributors, nil
+}
+
+// yamlKeyValue splits a "key: value" line. The key must be a plain word, so
+// a username like "org:sourcegraph" isn't taken for one.
+func yamlKeyValue(line string) (key, value string, ok bool) {
+	i := strings.Index(line, ":")
+	if i <= 0 || (i+1 < len(line) && line[i+1] != ' ') {
+		return "", "", false
+	}
+	key = line[:i]
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_') {
+			return "", "", false
+		}
+	}
+	return key, unquoteYAML(strings.TrimSpace(line[i+1:])), true
+}
+
+// unquoteYAML returns the value of a plain or quoted YAML scalar.
+func unquoteYAML(s string) string {
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return s
+	}
+	switch s[0] {
+	case '"':
+		if v, err := strconv.Unquote(s); err == nil {
+			return v
+		}
+		return s[1 : len(s)-1]
+	case '\'':
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1)
+	}
+	return s
+}
+
+// contributorFields are the keys read from a mapping or JSON object that
+// describes a Contributor.
+var contributorFields = []string{"login", "email", "signed", "version", "company"}
+
+// contributorFromFields returns the Contributor described by fields, which
+// are keyed by the names in contributorFields.
+func contributorFromFields(fields map[string]string) (Contributor, error) {
+	var c Contributor
+	for key, value := range fields {
+		switch key {
+		case "login":
+			c.Login = value
+		case "email":
+			c.Email = value
+		case "version":
+			c.Version = value
+		case "company":
+			c.Company = value
+		case "signed":
+			signed, err := parseSignedDate(value)
+			if err != nil {
+				return Contributor{}, err
+			}
+			c.Signed = signed
+		default:
+			return Contributor{}, fmt.Errorf("unknown key %q, want one of %s", key, strings.Join(contributorFields, ", "))
+		}
+	}
+	if c.Login == "" && c.Email == "" {
+		return Contributor{}, errors.New("no login or email")
+	}
+	return c, nil
+}
+
+// fetchTimeout is how long a fetcher waits for a list of contributors to
+// download. It is a little longer than the 30 second request timeouts common
+// in front of web apps, so those time out first and say why.
+const fetchTimeout = 31 * time.Second
+
+// maxDocumentSize is the largest document JSONFetcher reads. Lists of
+// contributors are far smaller; the limit keeps a misbehaving server from
+// exhausting memory.
+const maxDocumentSize = 10 << 20
+
+// JSONFetcher fetches contributors from a JSON document served over HTTPS.
+type JSONFetcher struct {
+	url string
+	// Path selects the contributors in the document. It is a
+	// JSONPath-style expression made of object keys and array indexes,
+	// where "[*]" selects every element of an array. For example,
+	// "$.signers[*].github" selects the "github" key of every object in the
+	// "signers" array. A selected string is a username. A selected object
+	// is read like a row of a spreadsheet, from its "login", "email",
+	// "signed", "version" and "company" keys; objects with neither a login
+	// nor an email, or with an invalid date, are skipped. If empty, the
+	// document must be an array of usernames or such objects.
+	Path string
+	// If Token is set, it is sent as a bearer token in the Authorization
+	// header.
+	Token string
+	// Client is used to fetch the document. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// NewJSONFetcher creates a JSONFetcher that selects contributors from the
+// document at url with path.
+func NewJSONFetcher(url, path string) *JSONFetcher {
+	return &JSONFetcher{url: url, Path: path}
+}
+
+// LoadContributors satisfies the ContributorFetcher interface.
+func (j *JSONFetcher) LoadContributors(ctx context.Context) ([]Contributor, error) {
+	u, err := url.Parse(j.url)
+	if err != nil {
+		return nil, err
+	}
+	if j.Token != "" && u.Scheme != "https" {
+		return nil, fmt.Errorf("refusing to send a token to %s over %s", u.Host, u.Scheme)
+	}
+	req, err := http.NewRequest("GET", j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "maintainerbot")
+	req.Header.Set("Accept", "application/json")
+	if j.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+j.Token)
+	}
+	reqctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	client := j.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(reqctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", j.url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDocumentSize {
+		return nil, fmt.Errorf("fetching %s: document is larger than %d bytes", j.url, maxDocumentSize)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("fetching %s: %v", j.url, err)
+	}
+	contributors, err := selectContributors(doc, j.Path)
+	if err != nil {
+		return nil, err
+	}
+	// An empty list would mark every open pull request as unsigned, so a
+	// path that doesn't match is an error.
+	if len(contributors) == 0 {
+		return nil, fmt.Errorf("no contributors at %q in %s", j.Path, j.url)
+	}
+	return contributors, nil
+}
+
+// selectContributors returns the contributors in doc that path selects.
+func selectContributors(doc interface{}, path string) ([]Contributor, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []interface{}{doc}
+	for _, step := range steps {
+		var next []interface{}
+		for _, node := range nodes {
+			switch v := node.(type) {
+			case map[string]interface{}:
+				if child, ok := v[step]; ok {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if step == "*" {
+					next = append(next, v...)
+				} else if i, err := strconv.Atoi(step); err == nil && i >= 0 && i < len(v) {
+					next = append(next, v[i])
+				}
+			}
+		}
+		nodes = next
+	}
+	if len(steps) == 0 {
+		// An unqualified path selects the entries of a top-level array.
+		if arr, ok := doc.([]interface{}); ok {
+			nodes = arr
+		}
+	}
+	var out []Contributor
+	for _, node := range nodes {
+		switch v := node.(type) {
+		case string:
+			if login := strings.TrimSpace(v); login != "" {
+				out = append(out, Contributor{Login: login})
+			}
+		case map[string]interface{}:
+			fields := make(map[string]string)
+			for _, key := range contributorFields {
+				switch value := v[key].(type) {
+				case string:
+					fields[key] = strings.TrimSpace(value)
+				case float64:
+					// Versions are often numbers.
+					fields[key] = strconv.FormatFloat(value, 'f', -1, 64)
+				}
+			}
+			c, err := contributorFromFields(fields)
+			if err != nil {
+				if len(fields) > 0 {
+					log.Printf("skipping CLA signer %v: %v", fields, err)
+				}
+				continue
+			}
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// parsePath splits a path like "$.signers[*].github" into its steps:
+// "signers", "*", "github".
+func parsePath(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	var steps []string
+	for path != "" {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path: empty key")
+			}
+			steps = append(steps, path[:end])
+			path = path[end:]
+		case '[':
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path: missing ]")
+			}
+			step := strings.Trim(path[1:end], `"'`)
+			steps = append(steps, step)
+			path = path[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid path: unexpected %q", path[0])
+		}
+	}
+	return steps, nil
+}
+
+// TeamFetcher loads the members of a GitHub organization, or of a team in
+// one, as contributors. The client needs permission to read the members;
+// otherwise GitHub only lists an organization's public members.
+type TeamFetcher struct {
+	ghc  *github.Client
+	org  string
+	team string
+}
+
+// NewTeamFetcher creates a TeamFetcher for the members of the team with the
+// given slug in org, or for all members of org if team is empty.
+func NewTeamFetcher(ghc *github.Client, org, team string) *TeamFetcher {
+	return &TeamFetcher{ghc: ghc, org: org, team: team}
+}
+
+// LoadContributors satisfies the ContributorFetcher interface.
+func (t *TeamFetcher) LoadContributors(ctx context.Context) ([]Contributor, error) {
+	var teamID int64
+	if t.team != "" {
+		id, err := findTeamID(ctx, t.ghc, t.org, t.team)
+		if err != nil {
+			return nil, err
+		}
+		if id == 0 {
+			return nil, fmt.Errorf("no team %q in the %s organization", t.team, t.org)
+		}
+		teamID = id
+	}
+	var members []Contributor
+	opt := github.ListOptions{PerPage: 100}
+	for {
+		var users []*github.User
+		var resp *github.Response
+		var err error
+		if teamID != 0 {
+			users, resp, err = t.ghc.Teams.ListTeamMembers(ctx, teamID, &github.TeamListTeamMembersOptions{ListOptions: opt})
+		} else {
+			users, resp, err = t.ghc.Organizations.ListMembers(ctx, t.org, &github.ListMembersOptions{ListOptions: opt})
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			members = append(members, Contributor{Login: u.GetLogin()})
+		}
+		if resp.NextPage == 0 {
+			return members, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// Union returns a ContributorFetcher that loads the contributors from every
+// one of fetchers. If someone is listed more than once, the first entry wins.
+// If any of the fetchers fails, the Union fails, rather than drop the
+// contributors it would have returned; wrap unreliable sources with Cached.
+func Union(fetchers ...ContributorFetcher) ContributorFetcher {
+	return union(fetchers)
+}
+
+type union []ContributorFetcher
+
+func (u union) LoadContributors(ctx context.Context) ([]Contributor, error) {
+	var all []Contributor
+	seen := make(map[string]bool)
+	for _, f := range u {
+		contributors, err := f.LoadContributors(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%T: %v", f, err)
+		}
+		for _, c := range contributors {
+			key := NormalizeLogin(c.Login)
+			if key == "" {
+				key = strings.ToLower(strings.TrimSpace(c.Email))
+			}
+			if !seen[key] {
+				seen[key] = true
+				all = append(all, c)
+			}
+		}
+	}
+	return all, nil
+}
+
+// WithTimeout returns a ContributorFetcher that gives up on f after d. f's
+// context is canceled at the deadline, and WithTimeout returns then even if f
+// doesn't notice.
+func WithTimeout(f ContributorFetcher, d time.Duration) ContributorFetcher {
+	return &timeoutFetcher{f, d}
+}
+
+type timeoutFetcher struct {
+	f ContributorFetcher
+	d time.Duration
+}
+
+func (t *timeoutFetcher) LoadContributors(ctx context.Context) ([]Contributor, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	type result struct {
+		contributors []Contributor
+		err          error
+	}
+	done := make(chan result, 1)
+	go func() {
+		contributors, err := t.f.LoadContributors(ctx)
+		done <- result{contributors, err}
+	}()
+	select {
+	case r := <-done:
+		return r.contributors, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("loading contributors: %v", ctx.Err())
+	}
+}
+
+// CachedFetcher keeps the last list of contributors its ContributorFetcher
+// loaded in a file, and returns it when the fetcher fails, so an outage of
+// the source doesn't stop the CLAChecker, even right after a restart. An
+// empty list counts as a failure, so a broken source can't remove everyone.
+//
+// The first call to LoadContributors returns the list in the file, if there
+// is one, without waiting for the source, which is only asked from the next
+// call on. A slow or broken source then doesn't hold up startup.
+type CachedFetcher struct {
+	f    ContributorFetcher
+	path string
+
+	mu      sync.Mutex
+	last    *cachedContributors
+	started bool
+}
+
+// cachedContributors is the format of the CachedFetcher's file.
+type cachedContributors struct {
+	Contributors []Contributor `json:"contributors"`
+	Fetched      time.Time     `json:"fetched"`
+}
+
+// Cached returns a CachedFetcher that stores the contributors f loads in the
+// file at path.
+func Cached(f ContributorFetcher, path string) *CachedFetcher {
+	return &CachedFetcher{f: f, path: path}
+}
+
+// LoadContributors satisfies the ContributorFetcher interface.
+func (c *CachedFetcher) LoadContributors(ctx context.Context) ([]Contributor, error) {
+	c.mu.Lock()
+	if !c.started {
+		c.started = true
+		last, err := c.load()
+		if err == nil {
+			c.last = last
+			c.mu.Unlock()
+			log.Printf("using the contributors saved %s until the next fetch", last.Fetched.Format(time.RFC3339))
+			return last.Contributors, nil
+		}
+		if !os.IsNotExist(err) {
+			log.Printf("reading cached contributors: %v", err)
+		}
+	}
+	c.mu.Unlock()
+
+	contributors, err := c.f.LoadContributors(ctx)
+	if err == nil && len(contributors) == 0 {
+		err = errors.New("no contributors")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.last = &cachedContributors{Contributors: contributors, Fetched: time.Now()}
+		if err := c.save(); err != nil {
+			log.Printf("saving contributors to %s: %v", c.path, err)
+		}
+		return contributors, nil
+	}
+	if c.last == nil {
+		return nil, err
+	}
+	log.Printf("loading contributors failed, using the list from %s: %v", c.last.Fetched.Format(time.RFC3339), err)
+	return c.last.Contributors, nil
+}
+
+func (c *CachedFetcher) load() (*cachedContributors, error) {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	last := new(cachedContributors)
+	if err := json.Unmarshal(data, last); err != nil {
+		return nil, fmt.Errorf("%s: %v", c.path, err)
+	}
+	if len(last.Contributors) == 0 {
+		return nil, fmt.Errorf("%s: no contributors", c.path)
+	}
+	return last, nil
+}
+
+// save writes c.last to the file, replacing it atomically.
+func (c *CachedFetcher) save() error {
+	data, err := json.Marshal(c.last)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
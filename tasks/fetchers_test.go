@@ -0,0 +1,294 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/maintainerbot/maintainerbottest"
+)
+
+func TestFileFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintainerbot-fetcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name, data, want string
+	}{
+		{"signers.txt", "kevinburke\n# former employees\n\norg:sourcegraph # corporate\n", "kevinburke, org:sourcegraph"},
+		{"signers.yaml", "# CLA signers\ncontributors:\n  - kevinburke\n  - \"org:sourcegraph\"\n", "kevinburke, org:sourcegraph"},
+		{"signers.yml", "- kevinburke\n- 'domain:example.com'\n", "kevinburke, domain:example.com"},
+		{"quoted.yaml", "- \"#kevinburke\" # not a comment inside quotes\n- pat#doe\n", "#kevinburke, pat#doe"},
+		{"signers.csv", string(csvFile), "kevinburke, kevinburke_test, test"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := ioutil.WriteFile(path, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := NewFileFetcher(path).LoadContributors(context.Background())
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if logins(got) != tt.want {
+			t.Errorf("%s: got %q, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	path := filepath.Join(dir, "signers.txt")
+	f := NewFileFetcher(path)
+	if _, err := f.LoadContributors(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("someone-else\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.LoadContributors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Login != "someone-else" {
+		t.Errorf("after the file changed: got %q, want [someone-else]", got)
+	}
+
+	// Mappings carry the details of the agreement, like a spreadsheet row.
+	path = filepath.Join(dir, "metadata.yaml")
+	data := `contributors:
+  - login: pat-doe
+    signed: 2018-03-02   # the date on the form
+    version: "2"
+    company: "Acme #1"
+  - email: dev@example.com
+  - kevinburke
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = NewFileFetcher(path).LoadContributors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Contributor{
+		{Login: "pat-doe", Signed: time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC), Version: "2", Company: "Acme #1"},
+		{Email: "dev@example.com"},
+		{Login: "kevinburke"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("YAML mappings: got %+v, want %+v", got, want)
+	}
+
+	bad := filepath.Join(dir, "bad.yaml")
+	if err := ioutil.WriteFile(bad, []byte("contributors:\n  kevinburke: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileFetcher(bad).LoadContributors(context.Background()); err == nil {
+		t.Error("loaded a YAML file that isn't a list")
+	}
+}
+
+func TestJSONFetcher(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{
+			"signers": [{"name": "Kevin", "github": "kevinburke"}, {"name": "Acme", "github": "org:acme"}, {"name": "No account"}],
+			"people": [{"login": "pat-doe", "signed": "2018-03-02", "version": 2, "company": "Acme Inc"}, {"email": "dev@example.com"}, {"name": "No account"}]
+		}`))
+	}))
+	defer srv.Close()
+
+	j := NewJSONFetcher(srv.URL, "$.signers[*].github")
+	j.Client = srv.Client()
+	if _, err := j.LoadContributors(context.Background()); err == nil {
+		t.Error("fetched contributors without a token")
+	}
+	j.Token = "secret"
+	got, err := j.LoadContributors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins(got) != "kevinburke, org:acme" {
+		t.Errorf("got %q, want [kevinburke org:acme]", got)
+	}
+
+	j.Path = "$.signers[0].name"
+	if got, err := j.LoadContributors(context.Background()); err != nil || len(got) != 1 || got[0].Login != "Kevin" {
+		t.Errorf("indexed path: got %q, %v, want [Kevin]", got, err)
+	}
+	j.Path = "$.people[*]"
+	got, err = j.LoadContributors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Contributor{
+		{Login: "pat-doe", Signed: time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC), Version: "2", Company: "Acme Inc"},
+		{Email: "dev@example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("objects: got %+v, want %+v", got, want)
+	}
+	j.Path = "$.contributors"
+	if _, err := j.LoadContributors(context.Background()); err == nil {
+		t.Error("a path that matches nothing didn't return an error")
+	}
+
+	insecure := NewJSONFetcher(strings.Replace(srv.URL, "https:", "http:", 1), "")
+	insecure.Token = "secret"
+	if _, err := insecure.LoadContributors(context.Background()); err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Errorf("sending a token over http: got %v, want an error", err)
+	}
+}
+
+func TestTeamFetcher(t *testing.T) {
+	s := maintainerbottest.NewServer()
+	defer s.Close()
+	s.AddOrgMember("acme", "alice")
+	s.AddOrgMember("acme", "bob")
+	s.AddTeam("acme", "eng", "bob")
+
+	got, err := NewTeamFetcher(s.Client(), "acme", "").LoadContributors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins(got) != "alice, bob" {
+		t.Errorf("org members: got %q, want [alice bob]", got)
+	}
+	got, err = NewTeamFetcher(s.Client(), "acme", "eng").LoadContributors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins(got) != "bob" {
+		t.Errorf("team members: got %q, want [bob]", got)
+	}
+	if _, err := NewTeamFetcher(s.Client(), "acme", "sales").LoadContributors(context.Background()); err == nil {
+		t.Error("loaded the members of a team that doesn't exist")
+	}
+}
+
+type funcFetcher func(ctx context.Context) ([]Contributor, error)
+
+func (f funcFetcher) LoadContributors(ctx context.Context) ([]Contributor, error) {
+	return f(ctx)
+}
+
+func TestCachedFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintainerbot-cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "contributors.json")
+	var list []Contributor
+	var fetchErr error
+	source := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		return list, fetchErr
+	})
+
+	fetchErr = errors.New("spreadsheet is down")
+	if _, err := Cached(source, path).LoadContributors(context.Background()); err == nil {
+		t.Fatal("got contributors without ever loading any")
+	}
+	list, fetchErr = []Contributor{{Login: "kevinburke"}}, nil
+	if _, err := Cached(source, path).LoadContributors(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart, the list from before is used while the source is
+	// down, or returns nothing.
+	for _, tt := range []struct {
+		list []Contributor
+		err  error
+	}{
+		{nil, errors.New("spreadsheet is down")},
+		{[]Contributor{}, nil},
+	} {
+		list, fetchErr = tt.list, tt.err
+		got, err := Cached(source, path).LoadContributors(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logins(got) != "kevinburke" {
+			t.Errorf("got %v, want the cached [kevinburke]", got)
+		}
+	}
+}
+
+func TestCachedFetcherStartup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintainerbot-cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "contributors.json")
+	if _, err := Cached(staticFetcher{"kevinburke"}, path).LoadContributors(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The source is down, and takes its time to say so.
+	calls := 0
+	down := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		calls++
+		time.Sleep(10 * time.Millisecond)
+		return nil, errors.New("spreadsheet is down")
+	})
+	f := Cached(down, path)
+	for i := 0; i < 2; i++ {
+		got, err := f.LoadContributors(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logins(got) != "kevinburke" {
+			t.Errorf("load %d: got %v, want the cached [kevinburke]", i, got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("asked the source %d times, want 1: not at startup, but on the next load", calls)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	u := Union(staticFetcher{"kevinburke", "org:acme"}, staticFetcher{"org:acme", "test"})
+	got, err := u.LoadContributors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins(got) != "kevinburke, org:acme, test" {
+		t.Errorf("got %q, want [kevinburke org:acme test]", got)
+	}
+	failing := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		return nil, errors.New("down")
+	})
+	if _, err := Union(staticFetcher{"kevinburke"}, failing).LoadContributors(context.Background()); err == nil {
+		t.Error("Union of a failing fetcher succeeded")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	stuck := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		select {}
+	})
+	start := time.Now()
+	if _, err := WithTimeout(stuck, 10*time.Millisecond).LoadContributors(context.Background()); err == nil {
+		t.Fatal("a fetcher that never returns succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WithTimeout returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,377 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// A ContributorFetcher's list can name corporate agreements as well as
+// individual logins. Entries with one of these prefixes cover everyone in a
+// GitHub organization, everyone in a team, given as "org/team-slug", or
+// everyone with an email address at a domain.
+const (
+	orgPrefix    = "org:"
+	teamPrefix   = "team:"
+	domainPrefix = "domain:"
+)
+
+// membershipTTL is how long CLAChecker remembers whether someone is covered
+// by a corporate agreement.
+const membershipTTL = time.Hour
+
+// signer is an entry in the list of contributors: an individual who signed
+// the CLA, or an organization, team or email domain covered by a corporate
+// CLA.
+type signer struct {
+	kind string // "user", "org", "team" or "domain"
+	name string
+}
+
+func parseSigner(s string) signer {
+	s = strings.TrimSpace(s)
+	for _, prefix := range []string{orgPrefix, teamPrefix, domainPrefix} {
+		if strings.HasPrefix(strings.ToLower(s), prefix) {
+			return signer{
+				kind: strings.TrimSuffix(prefix, ":"),
+				name: strings.ToLower(strings.TrimSpace(s[len(prefix):])),
+			}
+		}
+	}
+	return signer{kind: "user", name: s}
+}
+
+// agreement describes the CLA s stands for, for statuses and check runs.
+func (s signer) agreement() string {
+	switch s.kind {
+	case "org":
+		return "the " + s.name + " organization's CLA"
+	case "team":
+		return "the " + s.name + " team's CLA"
+	case "domain":
+		return "the CLA for " + s.name + " email addresses"
+	}
+	return "an individual CLA"
+}
+
+// coverage is a person who worked on a pull request and the agreement that
+// covers them: entry, the line in the list of contributors, which is signer.
+type coverage struct {
+	who    string
+	signer signer
+	entry  Contributor
+}
+
+// agreement describes the agreement that covers cov.who, with its version
+// and date if the list of contributors has them.
+func (cov coverage) agreement() string {
+	a := cov.signer.agreement()
+	if cov.signer.kind == "user" && cov.entry.Company != "" {
+		a = cov.entry.Company + "'s corporate CLA"
+	}
+	if d := cov.entry.details(); d != "" {
+		a += " (" + d + ")"
+	}
+	return a
+}
+
+// individual reports whether cov.who signed the CLA themselves, with nothing
+// else to say about it.
+func (cov coverage) individual() bool {
+	return cov.signer.kind == "user" && cov.entry.Company == ""
+}
+
+// claResult is the outcome of checking everyone who worked on a pull
+// request.
+type claResult struct {
+	// missing is who isn't covered by any agreement.
+	missing []string
+	// covered is everyone else.
+	covered []coverage
+}
+
+// signedDescription describes a success status for r.
+func (r claResult) signedDescription() string {
+	if len(r.covered) == 1 && r.covered[0].individual() {
+		if d := r.covered[0].entry.details(); d != "" {
+			return "Contributor has signed the CLA (" + d + ")"
+		}
+	}
+	var agreements []string
+	seen := make(map[string]bool)
+	for _, cov := range r.covered {
+		a := cov.agreement()
+		if cov.individual() || seen[a] {
+			continue
+		}
+		seen[a] = true
+		agreements = append(agreements, a)
+	}
+	if len(agreements) == 0 {
+		return "Contributor has signed the CLA"
+	}
+	return truncateDescription("Covered by " + strings.Join(agreements, ", "))
+}
+
+// membership is what membershipCache knows about someone: whether they
+// belong to an organization or team, or their public email address.
+type membership struct {
+	member  bool
+	email   string
+	checked time.Time
+}
+
+// membershipCache remembers the lookups needed to resolve corporate
+// agreements.
+type membershipCache struct {
+	mu      sync.Mutex
+	entries map[string]membership
+	teamIDs map[string]int64
+}
+
+func (m *membershipCache) get(key string) (membership, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || time.Since(e.checked) > membershipTTL {
+		return membership{}, false
+	}
+	return e, true
+}
+
+// reset forgets every lookup.
+func (m *membershipCache) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = nil
+}
+
+func (m *membershipCache) set(key string, e membership) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[string]membership)
+	}
+	e.checked = time.Now()
+	m.entries[key] = e
+}
+
+// resolveSigners checks whether everyone who worked on pr is covered by a
+// CLA: only the pull request's author, unless CheckAllAuthors is set.
+func (c *CLAChecker) resolveSigners(ctx context.Context, owner, repo string, pr *github.PullRequest) (claResult, error) {
+	people := []string{pr.GetUser().GetLogin()}
+	if c.CheckAllAuthors {
+		authors, err := c.commitAuthors(ctx, owner, repo, pr.GetNumber())
+		if err != nil {
+			return claResult{}, err
+		}
+		people = append(people, authors...)
+	}
+	var res claResult
+	// GitHub logins and email addresses aren't case sensitive, so "Foo"
+	// and "foo" are the same person.
+	seen := make(map[string]bool, len(people))
+	for _, who := range people {
+		if seen[strings.ToLower(who)] {
+			continue
+		}
+		seen[strings.ToLower(who)] = true
+		cov, ok, err := c.coveredBy(ctx, who)
+		if err != nil {
+			return claResult{}, err
+		}
+		if ok {
+			res.covered = append(res.covered, cov)
+		} else {
+			res.missing = append(res.missing, who)
+		}
+	}
+	return res, nil
+}
+
+// decision is whether someone is covered by a CLA, as found by coveredBy.
+type decision struct {
+	cov     coverage
+	ok      bool
+	checked time.Time
+}
+
+// coveredBy returns the agreement that covers who, a login or an email
+// address, and whether there is one. Decisions are cached until the list of
+// contributors changes, or for membershipTTL, since the memberships they may
+// depend on can change too.
+func (c *CLAChecker) coveredBy(ctx context.Context, who string) (coverage, bool, error) {
+	key := strings.ToLower(who)
+	c.contributorMu.Lock()
+	d, ok := c.decisions[key]
+	generation := c.generation
+	c.contributorMu.Unlock()
+	if ok && time.Since(d.checked) <= membershipTTL {
+		d.cov.who = who
+		return d.cov, d.ok, nil
+	}
+	cov, ok, err := c.lookupCoverage(ctx, who)
+	if err != nil {
+		return coverage{}, false, err
+	}
+	c.contributorMu.Lock()
+	// Don't cache a decision made with a list that has since changed.
+	if c.generation == generation {
+		if c.decisions == nil {
+			c.decisions = make(map[string]decision)
+		}
+		c.decisions[key] = decision{cov: cov, ok: ok, checked: time.Now()}
+	}
+	c.contributorMu.Unlock()
+	return cov, ok, nil
+}
+
+// forgetDecisions clears the decisions cached by coveredBy. The caller must
+// hold contributorMu.
+func (c *CLAChecker) forgetDecisions() {
+	c.decisions = nil
+	c.generation++
+}
+
+// lookupCoverage does the work for coveredBy. Individual signers are checked
+// first, since that doesn't take any requests.
+func (c *CLAChecker) lookupCoverage(ctx context.Context, who string) (coverage, bool, error) {
+	c.contributorMu.Lock()
+	entry, individual := c.contributors[strings.ToLower(who)]
+	corporate := c.corporate
+	c.contributorMu.Unlock()
+	if individual {
+		return coverage{who, signer{kind: "user", name: who}, entry}, true, nil
+	}
+	isEmail := strings.Contains(who, "@")
+	for _, entry := range corporate {
+		s := parseSigner(entry.Login)
+		var ok bool
+		var err error
+		switch {
+		case isEmail:
+			// Anyone can put any address on a commit, so only the
+			// verified email of a GitHub account counts for a domain,
+			// and organizations and teams are made of accounts.
+		case s.kind == "domain":
+			var email string
+			if email, err = c.userEmail(ctx, who); err != nil {
+				return coverage{}, false, err
+			}
+			ok = strings.HasSuffix(strings.ToLower(email), "@"+s.name)
+		default:
+			ok, err = c.isMember(ctx, s, who)
+		}
+		if err != nil {
+			return coverage{}, false, err
+		}
+		if ok {
+			return coverage{who, s, entry}, true, nil
+		}
+	}
+	return coverage{}, false, nil
+}
+
+// isMember reports whether login belongs to the organization or team s.
+func (c *CLAChecker) isMember(ctx context.Context, s signer, login string) (bool, error) {
+	key := s.kind + ":" + s.name + " " + strings.ToLower(login)
+	if e, ok := c.memberships.get(key); ok {
+		return e.member, nil
+	}
+	var ok bool
+	switch s.kind {
+	case "org":
+		var err error
+		ok, _, err = c.ghc.Organizations.IsMember(ctx, s.name, login)
+		if err != nil {
+			return false, err
+		}
+	case "team":
+		id, err := c.teamID(ctx, s.name)
+		if err != nil {
+			return false, err
+		}
+		if id != 0 {
+			m, _, err := c.ghc.Teams.GetTeamMembership(ctx, id, login)
+			if err != nil && !isNotFound(err) {
+				return false, err
+			}
+			ok = m.GetState() == "active"
+		}
+	}
+	c.memberships.set(key, membership{member: ok})
+	return ok, nil
+}
+
+// teamID returns the ID of the team named "org/team-slug", or 0 if there is
+// no such team.
+func (c *CLAChecker) teamID(ctx context.Context, name string) (int64, error) {
+	m := &c.memberships
+	m.mu.Lock()
+	id, ok := m.teamIDs[name]
+	m.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid team %q, want org/team-slug", name)
+	}
+	id, err := findTeamID(ctx, c.ghc, parts[0], parts[1])
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	if m.teamIDs == nil {
+		m.teamIDs = make(map[string]int64)
+	}
+	m.teamIDs[name] = id
+	m.mu.Unlock()
+	return id, nil
+}
+
+// userEmail returns the email address on login's public profile, or "" if
+// they don't show one. GitHub only lets users show a verified address.
+func (c *CLAChecker) userEmail(ctx context.Context, login string) (string, error) {
+	key := "email " + strings.ToLower(login)
+	if e, ok := c.memberships.get(key); ok {
+		return e.email, nil
+	}
+	user, _, err := c.ghc.Users.Get(ctx, login)
+	if err != nil && !isNotFound(err) {
+		return "", err
+	}
+	c.memberships.set(key, membership{email: user.GetEmail()})
+	return user.GetEmail(), nil
+}
+
+// findTeamID returns the ID of the team with the given slug in org, or 0 if
+// there is no such team.
+func findTeamID(ctx context.Context, ghc *github.Client, org, slug string) (int64, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		teams, resp, err := ghc.Teams.ListTeams(ctx, org, opt)
+		if err != nil {
+			return 0, err
+		}
+		for _, team := range teams {
+			if strings.EqualFold(team.GetSlug(), slug) {
+				return team.GetID(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return 0, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*github.ErrorResponse)
+	return ok && e.Response != nil && e.Response.StatusCode == http.StatusNotFound
+}
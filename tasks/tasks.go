@@ -18,29 +18,56 @@ package tasks
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"reflect"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/go-github/github"
+	"github.com/sourcegraph/maintainerbot"
 	"golang.org/x/build/maintner"
 )
 
+// Store persists task state across restarts, so a task doesn't have to redo
+// work, or rely on markers it left on GitHub, to know what it already
+// handled. A *maintainerbot.StateBucket satisfies Store.
+type Store interface {
+	// Get decodes the value for key into v and reports whether it was found.
+	Get(key string, v interface{}) (bool, error)
+	// Put stores v under key.
+	Put(key string, v interface{}) error
+	// Delete removes key, if it exists.
+	Delete(key string) error
+}
+
 // Congratulator congratulates new contributors, and posts a welcome message on
 // the first PR they opened against the project.
 //
 // To avoid posting the same message multiple times, Congratulator uses a label
 // ("new-contributor") to track when it has already posted a message on a given
 // pull request.
+//
+// Congratulator satisfies the maintainerbot.EventTask interface, so after its
+// first run it only looks at newly opened pull requests.
 type Congratulator struct {
-	ghc               *github.Client
-	message           *template.Template
+	// If Store is set, Congratulator records every pull request it welcomed
+	// in it, and doesn't depend on the "new-contributor" label after a
+	// restart.
+	Store Store
+
+	ghc     *github.Client
+	message *template.Template
+	// knownContributors holds, keyed by welcomeKey, who isn't new to a
+	// repository.
 	knownContributors map[string]bool
 }
 
@@ -80,22 +107,22 @@ func (c *Congratulator) Do(ctx context.Context, repo *maintner.GitHubRepo) error
 			return nil
 		}
 		username := gh.User.Login
-		if c.knownContributors[username] {
+		if c.knownContributors[welcomeKey(owner, repoName, username)] {
 			return nil
 		}
 		if _, ok := prs[username]; ok {
 			// this person has multiple PR's; not a new contributor.
-			c.knownContributors[username] = true
+			c.knownContributors[welcomeKey(owner, repoName, username)] = true
 			delete(prs, username)
 			return nil
 		}
 		prs[username] = gh
 		return nil
 	})
-	buf := new(bytes.Buffer)
 	for username, ghIssue := range prs {
+		key := welcomeKey(owner, repoName, username)
 		if ghIssue.Closed {
-			c.knownContributors[username] = true
+			c.knownContributors[key] = true
 			continue
 		}
 		hasNewContributorLabel := false
@@ -105,35 +132,99 @@ func (c *Congratulator) Do(ctx context.Context, repo *maintner.GitHubRepo) error
 				break
 			}
 		}
-		if hasNewContributorLabel {
-			c.knownContributors[username] = true
+		if hasNewContributorLabel || c.welcomed(owner, repoName, username) {
+			c.knownContributors[key] = true
 			continue
 		}
-		cdata := &CongratsData{
-			Username: ghIssue.User.Login,
-		}
-		buf.Reset()
-		err := c.message.Execute(buf, cdata)
-		if err != nil {
+		if err := c.welcome(ctx, owner, repoName, ghIssue); err != nil {
 			return err
 		}
-		// post label first, then post comment. if label succeeds but comment
-		// fails, too bad.
-		_, _, err = c.ghc.Issues.AddLabelsToIssue(ctx, owner, repoName, int(ghIssue.Number),
-			[]string{"new-contributor"}) // must match label name above
-		if err != nil {
-			return err
+		c.knownContributors[key] = true
+	}
+	return err
+}
+
+// errStopIteration stops a ForeachIssue loop early.
+var errStopIteration = errors.New("stop iteration")
+
+// HandleEvents satisfies the maintainerbot.EventTask interface. Once Do has
+// caught up with the repository, HandleEvents only looks at pull requests
+// opened since the last run, instead of every pull request in the repository.
+func (c *Congratulator) HandleEvents(ctx context.Context, repo *maintner.GitHubRepo, events []maintainerbot.Event) error {
+	owner, repoName := repo.ID().Owner, repo.ID().Repo
+	for _, ev := range events {
+		gi := ev.Issue
+		if ev.Type != maintainerbot.IssueOpened || gi == nil || !gi.PullRequest || gi.Closed || gi.User == nil {
+			continue
 		}
-		comment := &github.IssueComment{
-			Body: github.String(buf.String()),
+		if gi.HasLabel("new-contributor") || c.welcomed(owner, repoName, gi.User.Login) {
+			continue
 		}
-		_, _, err = c.ghc.Issues.CreateComment(ctx, owner, repoName, int(ghIssue.Number), comment)
-		if err != nil {
+		firstPR := true
+		repo.ForeachIssue(func(other *maintner.GitHubIssue) error {
+			if other != gi && other.PullRequest && !other.NotExist && other.User != nil && other.User.Login == gi.User.Login {
+				firstPR = false
+				return errStopIteration
+			}
+			return nil
+		})
+		if !firstPR {
+			continue
+		}
+		if err := c.welcome(ctx, owner, repoName, gi); err != nil {
 			return err
 		}
-		c.knownContributors[username] = true
 	}
-	return err
+	return nil
+}
+
+// welcome labels ghIssue as coming from a new contributor and posts the
+// congratulations message on it.
+func (c *Congratulator) welcome(ctx context.Context, owner, repoName string, ghIssue *maintner.GitHubIssue) error {
+	cdata := &CongratsData{
+		Username: ghIssue.User.Login,
+	}
+	buf := new(bytes.Buffer)
+	err := c.message.Execute(buf, cdata)
+	if err != nil {
+		return err
+	}
+	// post label first, then post comment. if label succeeds but comment
+	// fails, too bad.
+	_, _, err = c.ghc.Issues.AddLabelsToIssue(ctx, owner, repoName, int(ghIssue.Number),
+		[]string{"new-contributor"}) // must match label name above
+	if err != nil {
+		return err
+	}
+	comment := &github.IssueComment{
+		Body: github.String(buf.String()),
+	}
+	_, _, err = c.ghc.Issues.CreateComment(ctx, owner, repoName, int(ghIssue.Number), comment)
+	if err != nil {
+		return err
+	}
+	if c.Store != nil {
+		return c.Store.Put(welcomeKey(owner, repoName, ghIssue.User.Login), ghIssue.Number)
+	}
+	return nil
+}
+
+func welcomeKey(owner, repoName, username string) string {
+	return owner + "/" + repoName + ":" + username
+}
+
+// welcomed reports whether the Store says username was already welcomed to
+// the repository.
+func (c *Congratulator) welcomed(owner, repoName, username string) bool {
+	if c.Store == nil {
+		return false
+	}
+	var number int32
+	ok, err := c.Store.Get(welcomeKey(owner, repoName, username), &number)
+	if err != nil {
+		log.Printf("congratulator: reading state for %s: %v", username, err)
+	}
+	return ok
 }
 
 // CLAChecker can fetch and validate that pull request authors have signed
@@ -143,73 +234,241 @@ type CLAChecker struct {
 	// on matching PR's. If nil, all PR's are assumed to need a CLA.
 	CanSkipCLA func(*github.PullRequest, []*github.CommitFile) bool
 
-	signedCLAPRs       map[int32]bool
+	// BotLogin is the login of the user the client acts as, whose comments
+	// SetComments makes the CLAChecker's. A GitHub App acts as
+	// "<app-slug>[bot]", which maintainerbot.AppTokenSource.BotLogin
+	// returns; set it when authenticating as an App, since installation
+	// tokens can't look up their own user. If empty, the CLAChecker asks
+	// GitHub for the authenticated user.
+	BotLogin string
+
+	// If CheckAllAuthors is set, everyone who authored or committed one of
+	// a pull request's commits, or is named in a "Co-authored-by:" trailer,
+	// must have signed the CLA too, not just the person who opened it.
+	// People without a GitHub account linked to their commits are looked up
+	// by email address.
+	CheckAllAuthors bool
+
+	// If no list of contributors has loaded when Do needs one, Do tries to
+	// load it for up to LoadTimeout before giving up with an error. If zero,
+	// Do returns the error right away. Setting LoadTimeout lets a
+	// CLAChecker run without StartFetch, although the list is then only
+	// loaded once, or when Refresh is called.
+	LoadTimeout time.Duration
+
+	// RefreshInterval is how often StartFetch loads the list of
+	// contributors again. Defaults to one minute.
+	RefreshInterval time.Duration
+
+	// If SkipReason is set, the check run summary uses it to explain why
+	// CanSkipCLA skipped a pull request.
+	SkipReason func(*github.PullRequest, []*github.CommitFile) string
+
+	// If Store is set, CLAChecker records the last head commit of each open
+	// pull request that has a successful CLA status in it, so it doesn't
+	// have to check them again after a restart.
+	Store Store
+
+	// StatusContext is the context of the commit statuses, or the name of
+	// the check runs, the CLAChecker posts. Defaults to "cla-bot".
+	StatusContext string
+
+	// If UseCheckRuns is set, the CLAChecker publishes a check run instead of
+	// a commit status, with a summary of who needs to sign the CLA and a
+	// "Re-run" button. Only GitHub Apps can create check runs, so the client
+	// must authenticate with a maintainerbot.AppTokenSource. The button
+	// works once the Bot receives "check_run" webhooks.
+	UseCheckRuns bool
+
+	// signedHeads holds, keyed by prKey, the last head commit of each pull
+	// request known to have a successful CLA status. checkedPRs holds when
+	// each pull request was last updated at the time its head commit was
+	// found in signedHeads; until it is updated again, it has no new
+	// commits to check. Both are guarded by mu, and forgotten when the
+	// pull request is closed.
+	signedHeads map[string]string
+	checkedPRs  map[string]time.Time
+	// failedMissing holds, keyed by prKey, the head commit of each pull
+	// request with a failing CLA status and who the status said is
+	// missing, so the status and comment are updated when one of them
+	// signs. comments holds the CLAChecker's comment on each pull request,
+	// or nil if it has none. Both are guarded by mu, and forgotten when the
+	// pull request is closed.
+	failedMissing      map[string]string
+	comments           map[string]*github.IssueComment
+	mu                 sync.Mutex
 	ghc                *github.Client
 	claURL             string
 	contributorFetcher ContributorFetcher
 
-	contributorsLoaded chan struct{}
+	// missingComment and signedComment are set by SetComments. login is
+	// the client's own user, looked up when BotLogin is empty; it is
+	// guarded by mu.
+	missingComment *template.Template
+	signedComment  *template.Template
+	login          string
+
+	stopFetch context.CancelFunc
+	fetchDone chan struct{}
 
-	contributors  map[string]bool
+	// contributors holds the individual signers, keyed by their normalized
+	// login and email address, and corporate the organizations, teams and
+	// domains with a corporate CLA. contributors is nil until a list has
+	// been loaded; fetchErr is the last error loading one. decisions
+	// caches whether each person who worked on a pull request is covered,
+	// keyed by their lower cased login or email address; it is cleared,
+	// and generation incremented, when the list changes, and by Refresh.
+	contributors  map[string]Contributor
+	corporate     []Contributor
+	fetchErr      error
+	decisions     map[string]decision
+	generation    int
 	contributorMu sync.Mutex
+	memberships   membershipCache
 }
 
 // ContributorFetcher is any struct that can fetch and return a list of
 // contributors that have signed the CLA. You can provide a custom
 // implementation in CLAChecker, or use the provided SpreadsheetFetcher to fetch
 // from a Google Sheet.
+//
+// Besides GitHub usernames, a Contributor's Login can name a corporate
+// agreement: "org:name" covers the members of a GitHub organization,
+// "team:org/slug" the members of a team, and "domain:example.com" everyone
+// whose public GitHub email, which GitHub only allows to be a verified
+// address, is at that domain. The email on a commit isn't verified, so it is
+// never matched against a domain.
+//
+// Logins and email addresses are matched case insensitively, and logins may
+// be written as "@username" or as a link to a GitHub profile. Malformed
+// entries are logged and ignored.
 type ContributorFetcher interface {
-	LoadContributors(ctx context.Context) ([]string, error)
+	LoadContributors(ctx context.Context) ([]Contributor, error)
 }
 
-func (c *CLAChecker) loadContributors(ctx context.Context) {
-	sentContributors := false
-	ticker := time.NewTicker(time.Minute)
-	for ; true; <-ticker.C {
-		contributors, err := c.contributorFetcher.LoadContributors(ctx)
-		if err != nil {
-			log.Println("fetch err", err)
-			continue
+func (c *CLAChecker) loadContributors(ctx context.Context, loaded bool) {
+	defer close(c.fetchDone)
+	interval := c.RefreshInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-		contributorMap := make(map[string]bool, len(contributors))
-		for i := range contributors {
-			contributorMap[contributors[i]] = true
+
+		contributors, err := c.fetchContributors(ctx)
+		switch {
+		case err != nil && c.notLoaded() == nil:
+			log.Printf("reloading CLA signers: %v (keeping the previous list)", err)
+		case err != nil:
+			log.Printf("loading CLA signers: %v (no list loaded yet)", err)
+		case !loaded:
+			log.Printf("initial list of contributors loaded: %s", logins(contributors))
+			loaded = true
 		}
+	}
+}
+
+// fetchContributors replaces the list of contributors with the one from the
+// ContributorFetcher.
+func (c *CLAChecker) fetchContributors(ctx context.Context) ([]Contributor, error) {
+	contributors, err := c.contributorFetcher.LoadContributors(ctx)
+	if err != nil {
 		c.contributorMu.Lock()
-		c.contributors = contributorMap
+		c.fetchErr = err
 		c.contributorMu.Unlock()
-		if !sentContributors {
-			log.Printf("initial list of contributors loaded: " + strings.Join(contributors, ", "))
-			c.contributorsLoaded <- struct{}{}
-			close(c.contributorsLoaded)
-			sentContributors = true
+		return nil, err
+	}
+	contributors = validContributors(contributors)
+	contributorMap := make(map[string]Contributor, len(contributors))
+	var corporate []Contributor
+	for _, contributor := range contributors {
+		if parseSigner(contributor.Login).kind != "user" {
+			corporate = append(corporate, contributor)
+			continue
 		}
-
-		select {
-		case <-ctx.Done():
-			return
-		default:
+		// The first entry for someone wins.
+		for _, key := range []string{contributor.Login, emailIdentity(contributor.Email)} {
+			if _, ok := contributorMap[key]; key != "" && !ok {
+				contributorMap[key] = contributor
+			}
 		}
 	}
+	c.contributorMu.Lock()
+	if !reflect.DeepEqual(contributorMap, c.contributors) || !reflect.DeepEqual(corporate, c.corporate) {
+		c.forgetDecisions()
+	}
+	c.contributors = contributorMap
+	c.corporate = corporate
+	c.fetchErr = nil
+	c.contributorMu.Unlock()
+	return contributors, nil
+}
+
+// notLoaded returns an error if no list of contributors has been loaded yet.
+func (c *CLAChecker) notLoaded() error {
+	c.contributorMu.Lock()
+	defer c.contributorMu.Unlock()
+	switch {
+	case c.contributors != nil:
+		return nil
+	case c.fetchErr != nil:
+		return fmt.Errorf("no list of CLA signers has been loaded yet: %v", c.fetchErr)
+	}
+	return errors.New("no list of CLA signers has been loaded yet; call StartFetch or Refresh first")
+}
+
+// waitLoaded returns nil if a list of contributors has been loaded. If not,
+// it tries to load one for up to LoadTimeout.
+func (c *CLAChecker) waitLoaded(ctx context.Context) error {
+	err := c.notLoaded()
+	if err == nil || c.LoadTimeout <= 0 {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.LoadTimeout)
+	defer cancel()
+	if err := c.Refresh(ctx); err != nil {
+		return fmt.Errorf("no list of CLA signers has been loaded yet: %v", err)
+	}
+	return nil
+}
+
+// prKey identifies a pull request across all of the repositories a
+// CLAChecker runs against.
+func prKey(owner, repo string, number int32) string {
+	return fmt.Sprintf("%s/%s#%d", owner, repo, number)
+}
+
+func (c *CLAChecker) statusContext() string {
+	if c.StatusContext != "" {
+		return c.StatusContext
+	}
+	return "cla-bot"
 }
 
 // Post a status to a pull request on GitHub. If "state" is "unnecessary"
 // a successful status will be posted, with a separate message than the
-// "success" state.
-func (c *CLAChecker) postStatus(ctx context.Context, owner, repo, sha, state string) (*github.RepoStatus, error) {
+// "success" state. The description says who res found hasn't signed, or
+// which agreements cover them.
+func (c *CLAChecker) postStatus(ctx context.Context, owner, repo, sha, state string, res claResult) (*github.RepoStatus, error) {
 	sr := &github.RepoStatus{
 		State:   github.String(state),
-		Context: github.String("cla-bot"),
+		Context: github.String(c.statusContext()),
 	}
 	switch state {
 	case "failure":
-		sr.Description = github.String("Contributor has not signed the CLA")
+		sr.Description = github.String(c.notSignedDescription(res.missing))
 		sr.TargetURL = github.String(c.claURL)
 	case "unnecessary":
 		sr.Description = github.String("Changes do not require CLA submission")
 		sr.State = github.String("success")
 	case "success":
-		sr.Description = github.String("Contributor has signed the CLA")
+		sr.Description = github.String(res.signedDescription())
 	default:
 		panic("unknown state " + state)
 	}
@@ -217,9 +476,165 @@ func (c *CLAChecker) postStatus(ctx context.Context, owner, repo, sha, state str
 	return status, err
 }
 
+// maxDescription is the longest commit status description GitHub accepts.
+const maxDescription = 140
+
+// truncateDescription shortens desc to maxDescription bytes, if it is longer,
+// without splitting a UTF-8 encoded character.
+func truncateDescription(desc string) string {
+	if len(desc) <= maxDescription {
+		return desc
+	}
+	n := maxDescription - len("...")
+	for n > 0 && !utf8.RuneStart(desc[n]) {
+		n--
+	}
+	return desc[:n] + "..."
+}
+
+// notSignedDescription describes a failure status for the people in missing.
+func (c *CLAChecker) notSignedDescription(missing []string) string {
+	if !c.CheckAllAuthors || len(missing) == 0 {
+		return "Contributor has not signed the CLA"
+	}
+	names := make([]string, len(missing))
+	for i := range missing {
+		names[i] = displayName(missing[i])
+	}
+	return truncateDescription("CLA not signed by " + strings.Join(names, ", "))
+}
+
+// publish posts state on the pull request as a commit status or a check run,
+// and returns its ID.
+func (c *CLAChecker) publish(ctx context.Context, owner, repo string, pr *github.PullRequest, files []*github.CommitFile, state string, res claResult) (int64, error) {
+	if c.UseCheckRuns {
+		run, err := c.createCheckRun(ctx, owner, repo, pr, files, state, res)
+		return run.GetID(), err
+	}
+	status, err := c.postStatus(ctx, owner, repo, pr.GetHead().GetSHA(), state, res)
+	return status.GetID(), err
+}
+
+// lastState returns the state of the newest status or check run the
+// CLAChecker posted on sha, or "" if there is none.
+func (c *CLAChecker) lastState(ctx context.Context, owner, repo, sha string) (string, error) {
+	if c.UseCheckRuns {
+		return c.lastCheckRun(ctx, owner, repo, sha)
+	}
+	statuses, _, err := c.ghc.Repositories.ListStatuses(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return "", err
+	}
+	// Statuses are returned newest first.
+	for i := range statuses {
+		if statuses[i].GetContext() == c.statusContext() {
+			return statuses[i].GetState(), nil
+		}
+	}
+	return "", nil
+}
+
+// signed reports whether sha, the head commit of the pull request identified
+// by key, is known to have a successful CLA status.
+func (c *CLAChecker) signed(key, sha string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.signedHeads == nil {
+		c.signedHeads = make(map[string]string)
+	}
+	if head, ok := c.signedHeads[key]; ok {
+		return head == sha
+	}
+	if c.Store != nil {
+		var head string
+		if found, err := c.Store.Get(key, &head); err == nil && found {
+			c.signedHeads[key] = head
+			return head == sha
+		}
+	}
+	return false
+}
+
+func (c *CLAChecker) markSigned(key, sha string) error {
+	c.mu.Lock()
+	if c.signedHeads == nil {
+		c.signedHeads = make(map[string]string)
+	}
+	c.signedHeads[key] = sha
+	c.mu.Unlock()
+	if c.Store != nil {
+		return c.Store.Put(key, sha)
+	}
+	return nil
+}
+
+// forget drops what the CLAChecker knows about the pull request identified
+// by key, once it is closed.
+func (c *CLAChecker) forget(key string) error {
+	c.mu.Lock()
+	delete(c.signedHeads, key)
+	delete(c.checkedPRs, key)
+	delete(c.failedMissing, key)
+	delete(c.comments, key)
+	c.mu.Unlock()
+	if c.Store != nil {
+		return c.Store.Delete(key)
+	}
+	return nil
+}
+
+// missingChanged reports whether missing, the head commit of the pull
+// request identified by key and who hasn't signed the CLA for it, differs
+// from what its failing status says. A pull request the CLAChecker hasn't
+// seen fail since it started is taken to have an up to date status.
+func (c *CLAChecker) missingChanged(key, missing string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, ok := c.failedMissing[key]
+	if !ok {
+		c.setMissingLocked(key, missing)
+		return false
+	}
+	return prev != missing
+}
+
+func (c *CLAChecker) setMissing(key, missing string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setMissingLocked(key, missing)
+}
+
+func (c *CLAChecker) setMissingLocked(key, missing string) {
+	if c.failedMissing == nil {
+		c.failedMissing = make(map[string]string)
+	}
+	c.failedMissing[key] = missing
+}
+
+// upToDate reports whether the pull request identified by key hasn't changed
+// since its head commit was found to be signed.
+func (c *CLAChecker) upToDate(key string, updated time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	checked, ok := c.checkedPRs[key]
+	return ok && checked.Equal(updated)
+}
+
+func (c *CLAChecker) setUpToDate(key string, updated time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checkedPRs == nil {
+		c.checkedPRs = make(map[string]time.Time)
+	}
+	c.checkedPRs[key] = updated
+}
+
 // Do checks whether every open pull request in the repository has been
 // submitted by a user who signed the CLA. If not, Do posts a failing Status
 // Check on the pull request build until the user signs the CLA.
+//
+// The status is tracked per head commit, so each new commit pushed to a pull
+// request gets a status of its own.
 func (c *CLAChecker) Do(ctx context.Context, repo *maintner.GitHubRepo) error {
 	// loop over open PR's
 	// filter out those with completed CLA's/positive checks
@@ -232,81 +647,105 @@ func (c *CLAChecker) Do(ctx context.Context, repo *maintner.GitHubRepo) error {
 		if gh.PullRequest == false {
 			return nil
 		}
+		key := prKey(owner, repoName, gh.Number)
 		if gh.Closed == true {
-			return nil
+			return c.forget(key)
 		}
-		if c.signedCLAPRs == nil {
-			c.signedCLAPRs = make(map[int32]bool)
-		}
-		// if user is in contributors list, we can exit
-		if _, ok := c.signedCLAPRs[gh.Number]; ok {
+		// Pushing to a pull request updates it, so if it hasn't been
+		// updated, its head is still signed.
+		if c.upToDate(key, gh.Updated) {
 			return nil
 		}
-		<-c.contributorsLoaded
-		c.contributorMu.Lock()
-		_, ok := c.contributors[gh.User.Login]
-		c.contributorMu.Unlock()
-		pr, _, err := c.ghc.PullRequests.Get(ctx, owner, repoName, int(gh.Number))
-		if err != nil {
+		// Without a list, every pull request would fail the check.
+		if err := c.waitLoaded(ctx); err != nil {
 			return err
 		}
-		files, _, err := c.ghc.PullRequests.ListFiles(ctx, owner, repoName, int(gh.Number), nil)
+		signed, err := c.check(ctx, owner, repoName, int(gh.Number), false)
 		if err != nil {
 			return err
 		}
-		statuses, _, err := c.ghc.Repositories.ListStatuses(ctx, owner, repoName, pr.Head.GetSHA(), nil)
+		if signed {
+			c.setUpToDate(key, gh.Updated)
+		}
+		return nil
+	})
+	return err
+}
+
+// check posts whether the author of a pull request has signed the CLA on its
+// head commit, and reports whether the commit has a successful status. If the
+// newest status already says so, check only posts it again if force is set.
+func (c *CLAChecker) check(ctx context.Context, owner, repoName string, number int, force bool) (bool, error) {
+	pr, _, err := c.ghc.PullRequests.Get(ctx, owner, repoName, number)
+	if err != nil {
+		return false, err
+	}
+	sha := pr.GetHead().GetSHA()
+	key := prKey(owner, repoName, int32(number))
+	if !force && c.signed(key, sha) {
+		return true, nil
+	}
+	login := pr.GetUser().GetLogin()
+	res, err := c.resolveSigners(ctx, owner, repoName, pr)
+	if err != nil {
+		return false, err
+	}
+	ok := len(res.missing) == 0
+	prevState, err := c.lastState(ctx, owner, repoName, sha)
+	if err != nil {
+		return false, err
+	}
+	// The decision only depends on the authors if they signed the CLA, so
+	// the files are only needed for everyone else.
+	var files []*github.CommitFile
+	canSkipCLA := false
+	if !ok {
+		files, _, err = c.ghc.PullRequests.ListFiles(ctx, owner, repoName, number, nil)
 		if err != nil {
-			return err
+			return false, err
 		}
-		canSkipCLA := c.CanSkipCLA != nil && c.CanSkipCLA(pr, files)
-		if ok || canSkipCLA {
-			// fetch pull request status, add or change to success
-			postStatusState := "success"
-			if canSkipCLA {
-				postStatusState = "unnecessary"
-			}
-			for i := range statuses {
-				if statuses[i].GetContext() == "cla-bot" {
-					state := statuses[i].GetState()
-					if state == "success" {
-						c.signedCLAPRs[gh.Number] = true
-						return nil
-					}
-					_, err := c.postStatus(ctx, owner, repoName, *pr.Head.SHA, postStatusState)
-					if err != nil {
-						return err
-					}
-					log.Printf("user %q just signed CLA on PR %d, updated status to %q from previous value %q", gh.User.Login, gh.Number, postStatusState, state)
-					return nil
-				}
-			}
-			// no statuses on the pull request, post success
-			status, err := c.postStatus(ctx, owner, repoName, *pr.Head.SHA, postStatusState)
-			if err != nil {
-				return err
-			}
-			log.Printf("user %q signed CLA on PR %d, posted %q status %d", gh.User.Login, gh.Number, postStatusState, status.ID)
-			return nil
+		canSkipCLA = c.CanSkipCLA != nil && c.CanSkipCLA(pr, files)
+	}
+	if ok || canSkipCLA {
+		// fetch pull request status, add or change to success
+		postStatusState := "success"
+		if canSkipCLA {
+			postStatusState = "unnecessary"
 		}
-		foundStatus := false
-		for i := range statuses {
-			if statuses[i].GetContext() == "cla-bot" && statuses[i].GetState() == "failure" {
-				foundStatus = true
-				break
-			}
+		if prevState == "success" && !force {
+			return true, c.markSigned(key, sha)
 		}
-		if foundStatus {
-			return nil
+		if err := c.comment(ctx, owner, repoName, pr, postStatusState, res); err != nil {
+			log.Printf("updating CLA comment on PR %d: %v", number, err)
 		}
-		// post failing status check
-		status, err := c.postStatus(ctx, owner, repoName, *pr.Head.SHA, "failure")
+		id, err := c.publish(ctx, owner, repoName, pr, files, postStatusState, res)
 		if err != nil {
-			log.Fatal(err)
+			return false, err
 		}
-		log.Printf("user %q has not signed CLA on PR %d, added status %d", gh.User.Login, gh.Number, status.ID)
-		return nil
-	})
-	return err
+		if prevState != "" {
+			log.Printf("user %q just signed CLA on PR %d, updated status to %q from previous value %q", login, number, postStatusState, prevState)
+		} else {
+			log.Printf("user %q signed CLA on PR %d, posted %q status %d on %s", login, number, postStatusState, id, sha)
+		}
+		return true, c.markSigned(key, sha)
+	}
+	missing := sha + " " + strings.Join(res.missing, ",")
+	if prevState == "failure" && !force && !c.missingChanged(key, missing) {
+		return false, nil
+	}
+	// The status is what blocks the merge, so a comment that can't be
+	// posted mustn't stop it.
+	if err := c.comment(ctx, owner, repoName, pr, "failure", res); err != nil {
+		log.Printf("posting CLA comment on PR %d: %v", number, err)
+	}
+	// post failing status check
+	id, err := c.publish(ctx, owner, repoName, pr, files, "failure", res)
+	if err != nil {
+		return false, fmt.Errorf("posting failure status on PR %d: %v", number, err)
+	}
+	c.setMissing(key, missing)
+	log.Printf("%s has not signed CLA on PR %d, added status %d on %s", strings.Join(res.missing, ", "), number, id, sha)
+	return false, nil
 }
 
 func NewCLAChecker(ghc *github.Client, claURL string, fetcher ContributorFetcher) *CLAChecker {
@@ -317,11 +756,90 @@ func NewCLAChecker(ghc *github.Client, claURL string, fetcher ContributorFetcher
 	return c
 }
 
-// StartFetch will begin periodically fetching contributors from the background
-// repository, until the provided context is canceled.
-func (c *CLAChecker) StartFetch(ctx context.Context) {
-	c.contributorsLoaded = make(chan struct{}, 1)
-	go c.loadContributors(ctx)
+// StartFetch loads the list of contributors, then keeps fetching it in the
+// background every RefreshInterval, until the provided context is canceled or
+// Close is called.
+//
+// If the first load fails, StartFetch returns the error, and Do returns an
+// error instead of checking pull requests until a later fetch succeeds. Wrap
+// the fetcher with Cached to fall back to the last list that loaded.
+func (c *CLAChecker) StartFetch(ctx context.Context) error {
+	c.fetchDone = make(chan struct{})
+	ctx, c.stopFetch = context.WithCancel(ctx)
+	contributors, err := c.fetchContributors(ctx)
+	if err == nil {
+		log.Printf("initial list of contributors loaded: %s", logins(contributors))
+	}
+	go c.loadContributors(ctx, err == nil)
+	if err != nil {
+		return fmt.Errorf("loading the list of CLA signers: %v", err)
+	}
+	return nil
+}
+
+// Refresh loads the list of contributors now, rather than wait for the next
+// fetch, for example because someone just signed the CLA, and forgets who
+// was found to be covered by it, and who belongs to which organization or
+// team. If it fails, the CLAChecker keeps using the
+// list it has.
+func (c *CLAChecker) Refresh(ctx context.Context) error {
+	if _, err := c.fetchContributors(ctx); err != nil {
+		return fmt.Errorf("loading the list of CLA signers: %v", err)
+	}
+	c.contributorMu.Lock()
+	c.forgetDecisions()
+	c.contributorMu.Unlock()
+	c.memberships.reset()
+	return nil
+}
+
+// ReloadHandler returns an http.Handler that calls Refresh when it receives
+// a POST request, so the form people sign the CLA with can notify the bot
+// right away. Requests must carry secret in an "Authorization: Bearer
+// <secret>" header. ReloadHandler panics if secret is empty, since anyone
+// could then make the bot fetch the list. The handler responds with 204 No
+// Content once the list has loaded, or 502 Bad Gateway if loading it failed.
+//
+// Pull requests waiting on a new signer are checked again the next time Do
+// runs. If reloaded is not nil, it is called after every successful reload;
+// pass the Bot's Wake method to run Do right away.
+func (c *CLAChecker) ReloadHandler(secret string, reloaded func()) http.Handler {
+	if secret == "" {
+		panic("tasks: ReloadHandler needs a secret")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := c.Refresh(r.Context()); err != nil {
+			log.Printf("reloading contributors: %v", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if reloaded != nil {
+			reloaded()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Close stops fetching contributors, and waits for any fetch in progress to
+// return. The Bot calls Close when it shuts down.
+func (c *CLAChecker) Close() error {
+	if c.stopFetch == nil {
+		return nil
+	}
+	c.stopFetch()
+	<-c.fetchDone
+	return nil
 }
 
 // SpreadsheetFetcher fetches data from a Google spreadsheet. The
@@ -364,7 +882,7 @@ func downloadCSV(ctx context.Context, url string) ([]byte, error) {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", "maintainerbot")
-	reqctx, cancel := context.WithTimeout(ctx, 31*time.Second)
+	reqctx, cancel := context.WithTimeout(ctx, fetchTimeout)
 	defer cancel()
 	req = req.WithContext(reqctx)
 	resp, err := http.DefaultClient.Do(req)
@@ -379,10 +897,26 @@ func downloadCSV(ctx context.Context, url string) ([]byte, error) {
 	return body, nil
 }
 
-// getUsernames returns a list of email addresses from a string of bytes in CSV
-// format. The first row must have a column named "email" or "Email".
-func getUsernames(file []byte, columnName string) ([]string, error) {
+// metadataColumns are the words that identify the optional columns of a
+// spreadsheet of contributors, besides the one with their usernames.
+var metadataColumns = []struct {
+	field string
+	words []string
+}{
+	{"email", []string{"email", "e-mail"}},
+	{"signed", []string{"signed", "date", "timestamp"}},
+	{"version", []string{"version"}},
+	{"company", []string{"company", "corporat", "employer", "organization"}},
+}
+
+// getContributors returns the contributors in a string of bytes in CSV
+// format. The first row must have a column whose name contains columnName;
+// columns named like "Email", "Date signed", "CLA version" or "Company" fill
+// in the rest of each Contributor. Malformed rows are logged and skipped,
+// rather than fail the whole list.
+func getContributors(file []byte, columnName string) ([]Contributor, error) {
 	r := csv.NewReader(bytes.NewReader(file))
+	r.FieldsPerRecord = -1
 	record, err := r.Read()
 	if err != nil {
 		return nil, err
@@ -399,27 +933,75 @@ func getUsernames(file []byte, columnName string) ([]string, error) {
 	if column == -1 {
 		return nil, fmt.Errorf("no column named '%s'; quitting", columnName)
 	}
+	columns := make(map[string]int)
+	for i := range record {
+		if i == column {
+			continue
+		}
+		lower := strings.ToLower(record[i])
+	fields:
+		for _, m := range metadataColumns {
+			if _, ok := columns[m.field]; ok {
+				continue
+			}
+			for _, word := range m.words {
+				if strings.Contains(lower, word) {
+					columns[m.field] = i
+					break fields
+				}
+			}
+		}
+	}
 	records, err := r.ReadAll()
 	if err != nil {
 		return nil, err
 	}
-	emails := make([]string, 0)
+	contributors := make([]Contributor, 0)
 	for i := range records {
-		email := strings.TrimSpace(records[i][column])
-		if email == "" {
+		cell := func(field string) string {
+			j, ok := columns[field]
+			if !ok || j >= len(records[i]) {
+				return ""
+			}
+			return strings.TrimSpace(records[i][j])
+		}
+		if column >= len(records[i]) {
+			continue
+		}
+		c := Contributor{
+			Login:   strings.TrimSpace(records[i][column]),
+			Email:   cell("email"),
+			Version: cell("version"),
+			Company: cell("company"),
+		}
+		if c.Login == "" && c.Email == "" {
+			continue
+		}
+		// Row numbers start at 1, and the first row is the header.
+		row := i + 2
+		if signed := cell("signed"); signed != "" {
+			if c.Signed, err = parseSignedDate(signed); err != nil {
+				log.Printf("skipping row %d of the list of CLA signers: %v", row, err)
+				continue
+			}
+		}
+		if err := c.Validate(); err != nil {
+			log.Printf("skipping row %d of the list of CLA signers: %v", row, err)
 			continue
 		}
-		emails = append(emails, email)
+		contributors = append(contributors, c.Normalize())
 	}
-	return emails, nil
+	return contributors, nil
 }
 
 // LoadContributors satisfies the ContributorFetcher interface. In
 // particular, it fetches the provided sheetURL in NewSpreadsheetFetcher,
 // then searches for the first column with a first row that contains
 // SpreadsheetFetcher.ColumnName. All subsequent rows in that column are
-// returned.
-func (s *SpreadsheetFetcher) LoadContributors(ctx context.Context) ([]string, error) {
+// returned, along with the email address, signing date, agreement version and
+// company in the same row, if the spreadsheet has columns for them; see
+// getContributors.
+func (s *SpreadsheetFetcher) LoadContributors(ctx context.Context) ([]Contributor, error) {
 	body, err := downloadCSV(ctx, s.sheetURL)
 	if err != nil {
 		return nil, err
@@ -428,9 +1010,9 @@ func (s *SpreadsheetFetcher) LoadContributors(ctx context.Context) ([]string, er
 	if columnName == "" {
 		columnName = "GitHub Username"
 	}
-	usernames, err := getUsernames(body, s.ColumnName)
+	contributors, err := getContributors(body, columnName)
 	if err != nil {
 		return nil, err
 	}
-	return usernames, nil
+	return contributors, nil
 }
@@ -1,6 +1,23 @@
 package tasks
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/go-github/github"
+	"github.com/sourcegraph/maintainerbot/maintainerbottest"
+	"golang.org/x/build/maintner"
+)
 
 var csvFile = []byte(`Name,Em,Address,Country,Phone Number,Github Username
 Kevin Burke,kevin@burke.services,"123 Main St",USA,925-555-1234,kevinburke
@@ -8,15 +25,781 @@ Kevin Foo,anotheremail@burke.services,"123 Main St",USA,925-555-1234,kevinburke_
 TestUser,test@example.com,"123 Main St",USA,925-555-1234,test
 `)
 
-func TestGetUsernames(t *testing.T) {
-	usernames, err := getUsernames(csvFile, "github username")
+func TestGetContributors(t *testing.T) {
+	contributors, err := getContributors(csvFile, "github username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contributors) != 3 {
+		t.Errorf("wrong number of contributors: want 3 got %d", len(contributors))
+	}
+	if contributors[0].Login != "kevinburke" {
+		t.Errorf("wrong value for first contributor: want kevinburke, got %q", contributors[0].Login)
+	}
+}
+
+var signersCSV = []byte(`Timestamp,Email address,GitHub Username,CLA Version,Company
+3/1/2018 10:00:00,Kevin@Burke.services,@KevinBurke,2,
+3/2/2018 11:00:00,pat@acme.com, https://github.com/Pat-Doe/ ,2,Acme Inc
+yesterday,bad@example.com,baddate,2,
+3/3/2018 09:00:00,,not a username,2,
+3/4/2018 09:00:00,anonymous@example.com,,1,
+`)
+
+func TestGetContributorsMetadata(t *testing.T) {
+	contributors, err := getContributors(signersCSV, "github username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Contributor{
+		{Login: "kevinburke", Email: "kevin@burke.services", Signed: time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC), Version: "2"},
+		{Login: "pat-doe", Email: "pat@acme.com", Signed: time.Date(2018, 3, 2, 11, 0, 0, 0, time.UTC), Version: "2", Company: "Acme Inc"},
+		{Email: "anonymous@example.com", Signed: time.Date(2018, 3, 4, 9, 0, 0, 0, time.UTC), Version: "1"},
+	}
+	if len(contributors) != len(want) {
+		t.Fatalf("got %d contributors, want %d: %v", len(contributors), len(want), contributors)
+	}
+	for i := range want {
+		if contributors[i] != want[i] {
+			t.Errorf("contributor %d: got %+v, want %+v", i, contributors[i], want[i])
+		}
+	}
+}
+
+func TestNormalizeLogin(t *testing.T) {
+	for in, want := range map[string]string{
+		"kevinburke":                      "kevinburke",
+		" @KevinBurke ":                   "kevinburke",
+		"https://github.com/KevinBurke/":  "kevinburke",
+		"github.com/kevinburke?tab=repos": "kevinburke",
+		"www.github.com/kevinburke":       "kevinburke",
+		"org:Sourcegraph":                 "org:sourcegraph",
+	} {
+		if got := NormalizeLogin(in); got != want {
+			t.Errorf("NormalizeLogin(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// staticFetcher returns a fixed list of logins.
+type staticFetcher []string
+
+func (f staticFetcher) LoadContributors(ctx context.Context) ([]Contributor, error) {
+	contributors := make([]Contributor, len(f))
+	for i := range f {
+		contributors[i] = Contributor{Login: f[i]}
+	}
+	return contributors, nil
+}
+
+// memStore is a Store kept in memory.
+type memStore map[string]interface{}
+
+func (m memStore) Get(key string, v interface{}) (bool, error) {
+	value, ok := m[key]
+	if !ok {
+		return false, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (m memStore) Put(key string, v interface{}) error {
+	m[key] = v
+	return nil
+}
+
+func (m memStore) Delete(key string) error {
+	delete(m, key)
+	return nil
+}
+
+func addPR(s *maintainerbottest.Server, number int, user, sha string) {
+	s.AddPullRequest("sourcegraph", "sourcegraph", &github.PullRequest{
+		Number: github.Int(number),
+		User:   &github.User{Login: github.String(user)},
+		Head:   &github.PullRequestBranch{SHA: github.String(sha)},
+	}, nil)
+}
+
+// newCLATest starts a fake GitHub serving sourcegraph/sourcegraph with issues,
+// where each pull request's head is "sha" and its number, and returns it with
+// the repository and a CLAChecker that gets its list from fetcher. The caller
+// closes the server, and loads the list with Refresh or StartFetch.
+func newCLATest(t *testing.T, fetcher ContributorFetcher, issues ...maintainerbottest.Issue) (*maintainerbottest.Server, *maintner.GitHubRepo, *CLAChecker) {
+	t.Helper()
+	s := maintainerbottest.NewServer()
+	for _, issue := range issues {
+		if issue.PullRequest {
+			addPR(s, int(issue.Number), issue.User, fmt.Sprintf("sha%d", issue.Number))
+		}
+	}
+	repo, err := maintainerbottest.NewGitHubRepo("sourcegraph", "sourcegraph", issues...)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return s, repo, NewCLAChecker(s.Client(), "https://example.com/cla", fetcher)
+}
+
+func TestCLAChecker(t *testing.T) {
+	s, repo, c := newCLATest(t, staticFetcher{"kevinburke"},
+		maintainerbottest.Issue{Number: 1, User: "kevinburke", PullRequest: true},
+		maintainerbottest.Issue{Number: 2, User: "stranger", PullRequest: true},
+		maintainerbottest.Issue{Number: 3, User: "stranger", PullRequest: true, Closed: true},
+		maintainerbottest.Issue{Number: 4, User: "stranger"},
+	)
+	defer s.Close()
+	c.StartFetch(context.Background())
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		if err := c.Do(context.Background(), repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{"sha1": "success", "sha2": "failure"}
+	for sha, state := range want {
+		statuses := s.Statuses("sourcegraph", "sourcegraph", sha)
+		if len(statuses) != 1 {
+			t.Errorf("%s: got %d statuses, want 1", sha, len(statuses))
+			continue
+		}
+		if got := statuses[0].GetState(); got != state {
+			t.Errorf("%s: got state %q, want %q", sha, got, state)
+		}
+		if got := statuses[0].GetContext(); got != "cla-bot" {
+			t.Errorf("%s: got context %q, want cla-bot", sha, got)
+		}
+	}
+	if statuses := s.Statuses("sourcegraph", "sourcegraph", "sha3"); len(statuses) != 0 {
+		t.Errorf("closed PR: got %d statuses, want 0", len(statuses))
+	}
+}
+
+func TestCLACheckerAllAuthors(t *testing.T) {
+	// Anyone can commit as dev@example.com, so the domain doesn't cover
+	// a commit that isn't linked to an account.
+	s, repo, c := newCLATest(t, staticFetcher{"kevinburke", "pat", "domain:example.com"},
+		maintainerbottest.Issue{Number: 1, User: "kevinburke", PullRequest: true},
+	)
+	defer s.Close()
+	user := func(login string) *github.User { return &github.User{Login: github.String(login)} }
+	author := func(email string) *github.CommitAuthor { return &github.CommitAuthor{Email: github.String(email)} }
+	s.SetPullRequestCommits("sourcegraph", "sourcegraph", 1, []*github.RepositoryCommit{{
+		Author:    user("kevinburke"),
+		Committer: user("web-flow"),
+		Commit: &github.Commit{
+			Message: github.String("Fix typo\n\nCo-authored-by: Pat <12345+Pat@users.noreply.github.com>"),
+		},
+	}, {
+		Committer: user("kevinburke"),
+		Commit: &github.Commit{
+			Author:  author("Dev@Example.com"),
+			Message: github.String("Add feature"),
+		},
+	}})
+	c.CheckAllAuthors = true
+	c.StartFetch(context.Background())
+	defer c.Close()
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	statuses := s.Statuses("sourcegraph", "sourcegraph", "sha1")
+	if len(statuses) != 1 || statuses[0].GetState() != "failure" {
+		t.Fatalf("got statuses %v, want one failure", statuses)
+	}
+	if got, want := statuses[0].GetDescription(), "CLA not signed by dev@example.com"; got != want {
+		t.Errorf("got description %q, want %q", got, want)
+	}
+}
+
+func TestNotSignedDescriptionUTF8(t *testing.T) {
+	c := &CLAChecker{CheckAllAuthors: true}
+	var missing []string
+	for i := 0; i < 20; i++ {
+		missing = append(missing, "jürgen.müller@example.com")
+	}
+	desc := c.notSignedDescription(missing)
+	if len(desc) > maxDescription {
+		t.Errorf("description is %d bytes, want at most %d", len(desc), maxDescription)
+	}
+	if !utf8.ValidString(desc) || !strings.HasSuffix(desc, "...") {
+		t.Errorf("got description %q, want valid UTF-8 ending in ...", desc)
+	}
+	// Wherever the two-byte "ü"s fall, the cut is between characters.
+	for pad := 0; pad < 4; pad++ {
+		d := truncateDescription(strings.Repeat("a", maxDescription-4+pad) + "üüüü")
+		if !utf8.ValidString(d) {
+			t.Errorf("pad %d: truncated to invalid UTF-8 %q", pad, d)
+		}
+	}
+}
+
+func TestCLACheckerContributorMetadata(t *testing.T) {
+	fetcher := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		return []Contributor{
+			{Login: "@kevinburke", Version: "2", Signed: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)},
+			{Login: "https://github.com/Pat-Doe", Company: "Acme Inc"},
+			{Login: "not a username"},
+		}, nil
+	})
+	s, repo, c := newCLATest(t, fetcher,
+		maintainerbottest.Issue{Number: 1, User: "KevinBurke", PullRequest: true},
+		maintainerbottest.Issue{Number: 2, User: "pat-doe", PullRequest: true},
+	)
+	defer s.Close()
+	if err := c.StartFetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"sha1": "Contributor has signed the CLA (v2, signed 2018-03-01)",
+		"sha2": "Covered by Acme Inc's corporate CLA",
+	}
+	for sha, desc := range want {
+		statuses := s.Statuses("sourcegraph", "sourcegraph", sha)
+		if len(statuses) != 1 {
+			t.Errorf("%s: got %d statuses, want 1", sha, len(statuses))
+			continue
+		}
+		if got := statuses[0].GetDescription(); got != desc {
+			t.Errorf("%s: got description %q, want %q", sha, got, desc)
+		}
+	}
+}
+
+func TestCLACheckerCorporate(t *testing.T) {
+	s, repo, c := newCLATest(t, staticFetcher{"org:acme", "team:widgets/eng", "domain:example.com"},
+		maintainerbottest.Issue{Number: 1, User: "employee", PullRequest: true},
+		maintainerbottest.Issue{Number: 2, User: "teammate", PullRequest: true},
+		maintainerbottest.Issue{Number: 3, User: "dev", PullRequest: true},
+		maintainerbottest.Issue{Number: 4, User: "stranger", PullRequest: true},
+	)
+	defer s.Close()
+	s.AddOrgMember("acme", "employee")
+	s.AddTeam("widgets", "eng", "teammate")
+	s.AddUser(&github.User{Login: github.String("dev"), Email: github.String("dev@Example.com")})
+	c.StartFetch(context.Background())
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		if err := c.Do(context.Background(), repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{
+		"sha1": "Covered by the acme organization's CLA",
+		"sha2": "Covered by the widgets/eng team's CLA",
+		"sha3": "Covered by the CLA for example.com email addresses",
+		"sha4": "Contributor has not signed the CLA",
+	}
+	for sha, desc := range want {
+		statuses := s.Statuses("sourcegraph", "sourcegraph", sha)
+		if len(statuses) != 1 {
+			t.Errorf("%s: got %d statuses, want 1", sha, len(statuses))
+			continue
+		}
+		if got := statuses[0].GetDescription(); got != desc {
+			t.Errorf("%s: got description %q, want %q", sha, got, desc)
+		}
+	}
+	lookups := 0
+	for _, req := range s.Requests() {
+		if req == "GET /orgs/acme/members/stranger" {
+			lookups++
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("looked up the membership of an unsigned author %d times, want 1", lookups)
+	}
+}
+
+func TestCLACheckerAuthorCase(t *testing.T) {
+	s, repo, c := newCLATest(t, staticFetcher{"kevinburke"},
+		maintainerbottest.Issue{Number: 1, User: "Foo", PullRequest: true},
+	)
+	defer s.Close()
+	s.SetPullRequestCommits("sourcegraph", "sourcegraph", 1, []*github.RepositoryCommit{{
+		Author: &github.User{Login: github.String("foo")},
+		Commit: &github.Commit{Message: github.String("Add feature")},
+	}})
+	c.CheckAllAuthors = true
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	statuses := s.Statuses("sourcegraph", "sourcegraph", "sha1")
+	if len(statuses) != 1 || statuses[0].GetDescription() != "CLA not signed by @Foo" {
+		t.Errorf("got statuses %v, want one failure naming Foo once", statuses)
+	}
+}
+
+func TestCLACheckerNewCommits(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+	s, repo, c := newCLATest(t, staticFetcher{"kevinburke"},
+		maintainerbottest.Issue{Number: 1, User: "kevinburke", PullRequest: true, Created: created},
+	)
+	defer s.Close()
+	store := make(memStore)
+	c.Store = store
+	c.StartFetch(context.Background())
+	defer c.Close()
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	sent := len(s.Requests())
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Requests()) - sent; n != 0 {
+		t.Errorf("unchanged PR: sent %d requests, want 0", n)
+	}
+
+	// A new commit is pushed to the pull request.
+	addPR(s, 1, "kevinburke", "sha2")
+	repo, err := maintainerbottest.NewGitHubRepo("sourcegraph", "sourcegraph",
+		maintainerbottest.Issue{Number: 1, User: "kevinburke", PullRequest: true, Created: created, Updated: time.Now()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	for _, sha := range []string{"sha1", "sha2"} {
+		statuses := s.Statuses("sourcegraph", "sourcegraph", sha)
+		if len(statuses) != 1 || statuses[0].GetState() != "success" {
+			t.Errorf("%s: got statuses %v, want one success", sha, statuses)
+		}
+	}
+	if !reflect.DeepEqual(store, memStore{"sourcegraph/sourcegraph#1": "sha2"}) {
+		t.Errorf("got state %v, want only the last signed head", store)
+	}
+
+	// Closing the pull request forgets it.
+	repo, err = maintainerbottest.NewGitHubRepo("sourcegraph", "sourcegraph",
+		maintainerbottest.Issue{Number: 1, User: "kevinburke", PullRequest: true, Closed: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	if len(store) != 0 {
+		t.Errorf("got state %v after the PR was closed, want none", store)
+	}
+}
+
+func TestCLACheckerDecisionCache(t *testing.T) {
+	s, repo, c := newCLATest(t, staticFetcher{"org:acme"},
+		maintainerbottest.Issue{Number: 1, User: "stranger", PullRequest: true},
+		maintainerbottest.Issue{Number: 2, User: "stranger", PullRequest: true},
+	)
+	defer s.Close()
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lookups := func() int {
+		n := 0
+		for _, req := range s.Requests() {
+			if req == "GET /orgs/acme/members/stranger" {
+				n++
+			}
+		}
+		return n
+	}
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	if n := lookups(); n != 1 {
+		t.Errorf("checked whether stranger is covered %d times for two PRs, want 1", n)
+	}
+
+	// Joining acme only counts once the list is refreshed.
+	s.AddOrgMember("acme", "stranger")
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	for _, sha := range []string{"sha1", "sha2"} {
+		if statuses := s.Statuses("sourcegraph", "sourcegraph", sha); len(statuses) == 0 || statuses[0].GetState() != "success" {
+			t.Errorf("%s: got statuses %v, want a success after Refresh", sha, statuses)
+		}
+	}
+	if n := lookups(); n != 2 {
+		t.Errorf("got %d membership lookups, want 2", n)
+	}
+}
+
+func TestCLACheckerComment(t *testing.T) {
+	signers := []Contributor{{Login: "kevinburke"}}
+	fetcher := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		return signers, nil
+	})
+	s, repo, c := newCLATest(t, fetcher,
+		maintainerbottest.Issue{Number: 1, User: "stranger", PullRequest: true},
+	)
+	defer s.Close()
+	c.SetComments("{{ range .Missing }}{{ . }} {{ end }}please sign at {{ .CLAURL }}", "Thanks, @{{ .Username }}!")
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Someone else copying the marker mustn't pass for the bot's comment.
+	forged := "lgtm <!-- cla-bot comment -->"
+	s.AddComment("sourcegraph", "sourcegraph", 1, &github.IssueComment{
+		User: &github.User{Login: github.String("mallory")},
+		Body: github.String(forged),
+	})
+	for i := 0; i < 2; i++ {
+		if err := c.Do(context.Background(), repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	comments := s.Comments("sourcegraph", "sourcegraph", 1)
+	if len(comments) != 2 || comments[0].GetBody() != forged {
+		t.Fatalf("got %d comments, want the forged one untouched and 1 from the bot", len(comments))
+	}
+	comments = comments[1:]
+	if body := comments[0].GetBody(); !strings.HasPrefix(body, "@stranger please sign at https://example.com/cla") || !strings.Contains(body, "<!-- cla-bot comment -->") {
+		t.Errorf("got comment %q", body)
+	}
+
+	signers = append(signers, Contributor{Login: "stranger"})
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	comments = s.Comments("sourcegraph", "sourcegraph", 1)
+	if len(comments) != 2 {
+		t.Fatalf("got %d comments after signing, want the bot's one edited", len(comments))
+	}
+	comments = comments[1:]
+	if body := comments[0].GetBody(); !strings.HasPrefix(body, "Thanks, @stranger!") {
+		t.Errorf("got comment %q after signing", body)
+	}
+	if statuses := s.Statuses("sourcegraph", "sourcegraph", "sha1"); len(statuses) != 2 || statuses[0].GetState() != "success" {
+		t.Errorf("got statuses %v, want a success after the failure", statuses)
+	}
+}
+
+func TestCLACheckerCommentPartlySigned(t *testing.T) {
+	var mu sync.Mutex
+	signers := staticFetcher{"kevinburke"}
+	fetcher := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return signers.LoadContributors(ctx)
+	})
+	s, repo, c := newCLATest(t, fetcher,
+		maintainerbottest.Issue{Number: 1, User: "stranger", PullRequest: true},
+	)
+	defer s.Close()
+	s.SetPullRequestCommits("sourcegraph", "sourcegraph", 1, []*github.RepositoryCommit{{
+		Author: &github.User{Login: github.String("pat")},
+		Commit: &github.Commit{Message: github.String("Add feature")},
+	}})
+	c.CheckAllAuthors = true
+	c.SetComments("{{ range .Missing }}{{ . }} {{ end }}please sign", "thanks")
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+
+	// pat signs, but nothing is pushed.
+	mu.Lock()
+	signers = append(signers, "pat")
+	mu.Unlock()
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Do(context.Background(), repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	comments := s.Comments("sourcegraph", "sourcegraph", 1)
+	if len(comments) != 1 || !strings.HasPrefix(comments[0].GetBody(), "@stranger please sign") {
+		t.Errorf("got comments %v, want one naming only @stranger", comments)
+	}
+	statuses := s.Statuses("sourcegraph", "sourcegraph", "sha1")
+	if len(statuses) != 2 || statuses[0].GetDescription() != "CLA not signed by @stranger" {
+		t.Errorf("got statuses %v, want a second failure naming only @stranger", statuses)
+	}
+	lists := 0
+	for _, req := range s.Requests() {
+		if req == "GET /repos/sourcegraph/sourcegraph/issues/1/comments" {
+			lists++
+		}
+	}
+	if lists != 1 {
+		t.Errorf("listed the PR's comments %d times, want 1", lists)
+	}
+}
+
+func TestCLACheckerBotLogin(t *testing.T) {
+	s, repo, c := newCLATest(t, staticFetcher{"kevinburke"},
+		maintainerbottest.Issue{Number: 1, User: "stranger", PullRequest: true},
+	)
+	defer s.Close()
+	// GitHub Apps can't look up their own user, so it is configured.
+	c.BotLogin = maintainerbottest.Login
+	c.SetComments("please sign", "thanks")
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Do(context.Background(), repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if comments := s.Comments("sourcegraph", "sourcegraph", 1); len(comments) != 1 {
+		t.Errorf("got %d comments, want 1", len(comments))
+	}
+	for _, req := range s.Requests() {
+		if req == "GET /user" {
+			t.Error("looked up the authenticated user although BotLogin is set")
+		}
+	}
+}
+
+func TestCLACheckerCheckRuns(t *testing.T) {
+	var mu sync.Mutex
+	signers := staticFetcher{"kevinburke"}
+	fetcher := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return signers.LoadContributors(ctx)
+	})
+	s, repo, c := newCLATest(t, fetcher,
+		maintainerbottest.Issue{Number: 2, User: "stranger", PullRequest: true},
+	)
+	defer s.Close()
+	s.AddPullRequest("sourcegraph", "sourcegraph", &github.PullRequest{
+		Number: github.Int(2),
+		User:   &github.User{Login: github.String("stranger")},
+		Head:   &github.PullRequestBranch{SHA: github.String("sha2")},
+	}, []*github.CommitFile{
+		{Filename: github.String("main.go"), Status: github.String("modified")},
+		{Filename: github.String("old.go"), Status: github.String("removed")},
+	})
+	c.UseCheckRuns = true
+	c.StatusContext = "cla"
+	c.StartFetch(context.Background())
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		if err := c.Do(context.Background(), repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if statuses := s.Statuses("sourcegraph", "sourcegraph", "sha2"); len(statuses) != 0 {
+		t.Errorf("got %d statuses, want 0", len(statuses))
+	}
+	runs := s.CheckRuns("sourcegraph", "sourcegraph", "sha2")
+	if len(runs) != 1 {
+		t.Fatalf("got %d check runs, want 1", len(runs))
+	}
+	if runs[0].GetName() != "cla" || runs[0].GetConclusion() != "failure" {
+		t.Errorf("got check run %q with conclusion %q, want cla, failure", runs[0].GetName(), runs[0].GetConclusion())
+	}
+	if summary := runs[0].GetOutput().GetSummary(); !strings.Contains(summary, "@stranger") || !strings.Contains(summary, "https://example.com/cla") {
+		t.Errorf("summary doesn't name the author or link to the CLA:\n%s", summary)
+	}
+	annotations := runs[0].GetOutput().Annotations
+	if len(annotations) != 1 {
+		t.Fatalf("got %d annotations, want 1 for the file that wasn't removed", len(annotations))
+	}
+	if a := annotations[0]; a.GetFileName() != "main.go" || a.GetWarningLevel() != "failure" || !strings.Contains(a.GetMessage(), "@stranger") {
+		t.Errorf("got annotation on %q at level %q saying %q", a.GetFileName(), a.GetWarningLevel(), a.GetMessage())
+	}
+
+	// The author signs, and presses "Re-run".
+	mu.Lock()
+	signers = append(signers, "stranger")
+	mu.Unlock()
+	event := &github.CheckRunEvent{
+		Action:   github.String("requested_action"),
+		CheckRun: runs[0],
+		Repo: &github.Repository{
+			Name:  github.String("sourcegraph"),
+			Owner: &github.User{Login: github.String("sourcegraph")},
+		},
+	}
+	if err := c.HandleWebhook(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	runs = s.CheckRuns("sourcegraph", "sourcegraph", "sha2")
+	if len(runs) != 2 || runs[1].GetConclusion() != "success" {
+		t.Fatalf("after re-run: got %d check runs, want a second one that succeeded", len(runs))
+	}
+}
+
+func TestCLACheckerNoContributors(t *testing.T) {
+	failing := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		return nil, errors.New("spreadsheet is down")
+	})
+	s, repo, c := newCLATest(t, failing,
+		maintainerbottest.Issue{Number: 1, User: "kevinburke", PullRequest: true},
+	)
+	defer s.Close()
+	if err := c.StartFetch(context.Background()); err == nil || !strings.Contains(err.Error(), "spreadsheet is down") {
+		t.Errorf("StartFetch: got %v, want the fetch error", err)
+	}
+	defer c.Close()
+	if err := c.Do(context.Background(), repo); err == nil {
+		t.Error("Do succeeded without a list of contributors")
+	}
+	if statuses := s.Statuses("sourcegraph", "sourcegraph", "sha1"); len(statuses) != 0 {
+		t.Errorf("got %d statuses, want 0", len(statuses))
+	}
+}
+
+func TestCLACheckerLoadTimeout(t *testing.T) {
+	s, repo, c := newCLATest(t, staticFetcher{"kevinburke"},
+		maintainerbottest.Issue{Number: 1, User: "kevinburke", PullRequest: true},
+	)
+	defer s.Close()
+	if err := c.Do(context.Background(), repo); err == nil || !strings.Contains(err.Error(), "StartFetch") {
+		t.Errorf("Do without StartFetch: got %v, want an error", err)
+	}
+	c.LoadTimeout = time.Second
+	if err := c.Do(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	if statuses := s.Statuses("sourcegraph", "sourcegraph", "sha1"); len(statuses) != 1 || statuses[0].GetState() != "success" {
+		t.Errorf("got statuses %v, want one success", statuses)
+	}
+}
+
+func TestCLACheckerRefresh(t *testing.T) {
+	var mu sync.Mutex
+	list := []Contributor{{Login: "kevinburke"}}
+	loads := 0
+	fetcher := funcFetcher(func(ctx context.Context) ([]Contributor, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		loads++
+		return list, nil
+	})
+	s := maintainerbottest.NewServer()
+	defer s.Close()
+	c := NewCLAChecker(s.Client(), "https://example.com/cla", fetcher)
+	c.RefreshInterval = time.Millisecond
+	if err := c.StartFetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := loads
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("loaded the list %d times, want it reloaded every RefreshInterval", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Close()
+
+	mu.Lock()
+	list = append(list, Contributor{Login: "newcomer"})
+	mu.Unlock()
+	wakes := 0
+	h := c.ReloadHandler("secret", func() { wakes++ })
+	for _, tt := range []struct {
+		method, auth string
+		code         int
+	}{
+		{"GET", "Bearer secret", http.StatusMethodNotAllowed},
+		{"POST", "", http.StatusUnauthorized},
+		{"POST", "Bearer wrong", http.StatusUnauthorized},
+		{"POST", "secret", http.StatusUnauthorized},
+		{"POST", "Bearer secret", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(tt.method, "/cla-signed", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s with %q: got %d, want %d", tt.method, tt.auth, w.Code, tt.code)
+		}
+	}
+	if wakes != 1 {
+		t.Errorf("reloaded was called %d times, want 1", wakes)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("ReloadHandler accepted an empty secret")
+			}
+		}()
+		c.ReloadHandler("", nil)
+	}()
+	if _, ok, err := c.coveredBy(context.Background(), "newcomer"); err != nil || !ok {
+		t.Errorf("newcomer isn't covered after a reload: %v", err)
+	}
+}
+
+func TestCongratulator(t *testing.T) {
+	s := maintainerbottest.NewServer()
+	defer s.Close()
+	repo, err := maintainerbottest.NewGitHubRepo("sourcegraph", "sourcegraph",
+		maintainerbottest.Issue{Number: 1, User: "newbie", PullRequest: true},
+		maintainerbottest.Issue{Number: 2, User: "regular", PullRequest: true, Closed: true},
+		maintainerbottest.Issue{Number: 3, User: "regular", PullRequest: true},
+		maintainerbottest.Issue{Number: 4, User: "welcomed", PullRequest: true, Labels: []string{"new-contributor"}},
+		maintainerbottest.Issue{Number: 5, User: "reporter"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCongratulator(s.Client(), "Congrats, @{{ .Username }}!")
+	for i := 0; i < 2; i++ {
+		if err := c.Do(context.Background(), repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	comments := s.Comments("sourcegraph", "sourcegraph", 1)
+	if len(comments) != 1 || comments[0].GetBody() != "Congrats, @newbie!" {
+		t.Errorf("PR 1: got comments %v, want one congratulation", comments)
+	}
+	if labels := s.Labels("sourcegraph", "sourcegraph", 1); len(labels) != 1 || labels[0] != "new-contributor" {
+		t.Errorf("PR 1: got labels %q, want [new-contributor]", labels)
+	}
+	for n := 2; n <= 5; n++ {
+		if comments := s.Comments("sourcegraph", "sourcegraph", n); len(comments) != 0 {
+			t.Errorf("#%d: got %d comments, want 0", n, len(comments))
+		}
+	}
+
+	// Being known in one repository doesn't make someone known in another.
+	other, err := maintainerbottest.NewGitHubRepo("sourcegraph", "other",
+		maintainerbottest.Issue{Number: 1, User: "regular", PullRequest: true},
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(usernames) != 3 {
-		t.Errorf("wrong number of emails: want 3 got %d", len(usernames))
+	if err := c.Do(context.Background(), other); err != nil {
+		t.Fatal(err)
 	}
-	if usernames[0] != "kevinburke" {
-		t.Errorf("wrong value for first email: want kevinburke, got %q", usernames[0])
+	if comments := s.Comments("sourcegraph", "other", 1); len(comments) != 1 || comments[0].GetBody() != "Congrats, @regular!" {
+		t.Errorf("first PR in another repo: got comments %v, want one congratulation", comments)
 	}
 }
@@ -0,0 +1,193 @@
+package maintainerbot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/build/maintner"
+)
+
+// WebhookTask can be implemented by a Task that wants to react to GitHub
+// webhook events as soon as they arrive, instead of waiting for the next
+// corpus update. event is one of *github.PullRequestEvent,
+// *github.IssuesEvent, *github.IssueCommentEvent, *github.StatusEvent or
+// *github.CheckRunEvent.
+//
+// HandleWebhook is called from the webhook handler's goroutine, possibly at
+// the same time as Do, so the Task must synchronize access to its own state.
+// The Task's Do method still runs on the usual schedule, which makes polling
+// a fallback for missed deliveries.
+type WebhookTask interface {
+	HandleWebhook(ctx context.Context, event interface{}) error
+}
+
+// maxWebhookPayload is the largest webhook body GitHub will send.
+const maxWebhookPayload = 25 << 20
+
+// webhookEvents are the X-GitHub-Event types the Bot reacts to. Other events
+// are acknowledged and ignored.
+var webhookEvents = map[string]bool{
+	"pull_request":  true,
+	"issues":        true,
+	"issue_comment": true,
+	"status":        true,
+	"check_run":     true,
+}
+
+// WebhookHandler returns an http.Handler that receives GitHub webhook
+// deliveries. secret must match the secret configured for the webhook on
+// GitHub; requests without a valid X-Hub-Signature-256 header are rejected.
+//
+// Each accepted event wakes the Bot, so the corpus is synced and tasks run
+// right away instead of at the next 15 second tick, and is passed to every
+// registered WebhookTask that wants the event's repository. Once Run has
+// started shutting down, events are answered with 503 Service Unavailable.
+func (b *Bot) WebhookHandler(secret []byte) http.Handler {
+	return &webhookHandler{bot: b, secret: secret}
+}
+
+type webhookHandler struct {
+	bot    *Bot
+	secret []byte
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkSignature(r.Header.Get("X-Hub-Signature-256"), body, h.secret); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	payload := body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload = []byte(form.Get("payload"))
+	}
+	eventType := github.WebHookType(r)
+	if !webhookEvents[eventType] {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.bot.dispatchWebhook(event) {
+		http.Error(w, "Bot is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	h.bot.queueWebhookEvents(event)
+	h.bot.Wake()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// checkSignature reports whether sig, the value of the X-Hub-Signature-256
+// header, is a valid HMAC of body for secret.
+func checkSignature(sig string, body, secret []byte) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("webhook secret is not configured")
+	}
+	if !strings.HasPrefix(sig, "sha256=") {
+		return fmt.Errorf("missing or malformed X-Hub-Signature-256 header")
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil {
+		return fmt.Errorf("malformed X-Hub-Signature-256 header: %v", err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("payload signature check failed")
+	}
+	return nil
+}
+
+// Wake asks a running Bot to sync the corpus and run its tasks now, instead
+// of waiting for the next tick. It does not block.
+func (b *Bot) Wake() {
+	select {
+	case b.wakeChan() <- struct{}{}:
+	default:
+		// A wake up is already pending.
+	}
+}
+
+func (b *Bot) wakeChan() chan struct{} {
+	b.wakeOnce.Do(func() {
+		b.wakeup = make(chan struct{}, 1)
+	})
+	return b.wakeup
+}
+
+// webhookRepo returns the repository an event belongs to.
+func webhookRepo(event interface{}) maintner.GitHubRepoID {
+	var repo *github.Repository
+	switch e := event.(type) {
+	case *github.PullRequestEvent:
+		repo = e.GetRepo()
+	case *github.IssuesEvent:
+		repo = e.GetRepo()
+	case *github.IssueCommentEvent:
+		repo = e.GetRepo()
+	case *github.StatusEvent:
+		repo = e.GetRepo()
+	case *github.CheckRunEvent:
+		repo = e.GetRepo()
+	}
+	return maintner.GitHubRepoID{Owner: repo.GetOwner().GetLogin(), Repo: repo.GetName()}
+}
+
+// dispatchWebhook passes event to every WebhookTask that wants it. Each
+// handler runs in its own goroutine, so a slow Task doesn't delay the
+// response to GitHub. It drops the event and returns false once the Bot has
+// started shutting down.
+func (b *Bot) dispatchWebhook(event interface{}) bool {
+	b.inflightMu.Lock()
+	defer b.inflightMu.Unlock()
+	if b.closing {
+		return false
+	}
+	id := webhookRepo(event)
+	for _, e := range b.tasks {
+		if _, ok := e.task.(WebhookTask); !ok || !e.wantRepo(id) {
+			continue
+		}
+		b.inflight.Add(1)
+		go func(e *taskEntry) {
+			defer b.inflight.Done()
+			if err := e.handleWebhook(b.taskContext(), event); err != nil {
+				log.Printf("%T: webhook for %s: %v", e.task, id, err)
+			}
+		}(e)
+	}
+	return true
+}
+
+// handleWebhook passes event to the Task.
+func (e *taskEntry) handleWebhook(ctx context.Context, event interface{}) error {
+	return e.call(ctx, func(ctx context.Context) error {
+		return e.task.(WebhookTask).HandleWebhook(ctx, event)
+	})
+}
@@ -0,0 +1,121 @@
+package maintainerbot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/build/maintner"
+)
+
+var prOpenedPayload = []byte(`{
+  "action": "opened",
+  "number": 7,
+  "pull_request": {"number": 7, "head": {"sha": "abc123"}},
+  "repository": {"name": "sourcegraph", "owner": {"login": "sourcegraph"}}
+}`)
+
+type hookTask struct {
+	events chan interface{}
+}
+
+func (h *hookTask) Do(ctx context.Context, repo *maintner.GitHubRepo) error { return nil }
+
+func (h *hookTask) HandleWebhook(ctx context.Context, event interface{}) error {
+	h.events <- event
+	return nil
+}
+
+func sign(body, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	secret := []byte("hunter2")
+	b := new(Bot)
+	task := &hookTask{events: make(chan interface{}, 1)}
+	b.RegisterTask(task)
+	skipped := &hookTask{events: make(chan interface{}, 1)}
+	b.RegisterTask(skipped, SkipRepos("sourcegraph/sourcegraph"))
+	h := b.WebhookHandler(secret)
+
+	tests := []struct {
+		event, sig string
+		want       int
+	}{
+		{"pull_request", "", http.StatusUnauthorized},
+		{"pull_request", sign(prOpenedPayload, []byte("wrong")), http.StatusUnauthorized},
+		{"watch", sign(prOpenedPayload, secret), http.StatusNoContent},
+		{"pull_request", sign(prOpenedPayload, secret), http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(prOpenedPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", tt.event)
+		if tt.sig != "" {
+			req.Header.Set("X-Hub-Signature-256", tt.sig)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s event with signature %q: got status %d, want %d", tt.event, tt.sig, w.Code, tt.want)
+		}
+	}
+
+	select {
+	case event := <-task.events:
+		pr, ok := event.(*github.PullRequestEvent)
+		if !ok {
+			t.Fatalf("got event of type %T, want *github.PullRequestEvent", event)
+		}
+		if pr.GetPullRequest().GetHead().GetSHA() != "abc123" {
+			t.Errorf("wrong head SHA: %q", pr.GetPullRequest().GetHead().GetSHA())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for webhook event")
+	}
+	select {
+	case <-b.wakeChan():
+	default:
+		t.Error("webhook did not wake the bot")
+	}
+	select {
+	case <-skipped.events:
+		t.Error("event delivered to a task that skips the repository")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestWebhookHandlerShutdown(t *testing.T) {
+	secret := []byte("hunter2")
+	b := new(Bot)
+	task := &hookTask{events: make(chan interface{}, 1)}
+	b.RegisterTask(task)
+	h := b.WebhookHandler(secret)
+	if err := b.shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(prOpenedPayload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", "pull_request")
+	req.Header.Set("X-Hub-Signature-256", sign(prOpenedPayload, secret))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("after shutdown: got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	select {
+	case <-task.events:
+		t.Error("event delivered after shutdown")
+	case <-time.After(10 * time.Millisecond):
+	}
+}